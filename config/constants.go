@@ -4,15 +4,37 @@ import "time"
 
 const (
 	ContextBearerToken = "BearerToken"
+	ContextClaims      = "Claims"
+	ContextScopesClaim = "ScopesClaim"
 )
 
 const (
-	DefaultClientTimeout = time.Second * 10
-	DefaultCtxTimeout    = time.Second * 10
-	DefaultIdleTimeout   = time.Second * 60
-	DefaultReadTimeout   = time.Second * 10
-	DefaultTokenLeeway   = time.Second * 30
-	DefaultWriteTimeout  = time.Second * 10
+	DefaultClientTimeout      = time.Second * 10
+	DefaultCtxTimeout         = time.Second * 10
+	DefaultDiscoveryMaxAge    = time.Hour * 1
+	DefaultDiscoveryMinRetry  = time.Second * 30
+	DefaultDpopProofMaxAge    = time.Minute * 5
+	DefaultIdleTimeout        = time.Second * 60
+	DefaultJwksFileCheck      = time.Second * 1
+	DefaultJwksMaxAge         = time.Minute * 10
+	DefaultJwksMinMaxAge      = time.Minute * 1
+	DefaultJwksMaxMaxAge      = time.Hour * 24
+	DefaultJwksMinRefetch     = time.Second * 30
+	DefaultJwksPublishMaxAge  = time.Minute * 5
+	DefaultJwksRefreshAhead   = time.Minute * 1
+	DefaultJwksStaleIfError   = time.Hour * 1
+	DefaultReadTimeout        = time.Second * 10
+	DefaultRevocationRefresh  = time.Second * 30
+	DefaultSigningKeyGrace    = time.Hour * 24
+	DefaultSigningKeyRotation = time.Hour * 24 * 7
+	DefaultTokenLeeway        = time.Second * 30
+	DefaultWriteTimeout       = time.Second * 10
+)
+
+const (
+	DefaultMaxBodySize     = 1 << 20
+	DefaultSignatureHeader = "X-JWS-Signature"
+	DefaultSigningAlg      = "RS256"
 )
 
 const (
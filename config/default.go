@@ -1,12 +1,49 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// TokenIssuerConfig describes an additional trusted token issuer, read
+// from the TOKEN_ISSUERS JSON array. An issuer may appear once per
+// audience
+type TokenIssuerConfig struct {
+	Issuer         string   `json:"issuer"`
+	Audience       string   `json:"audience"`
+	JwksUrl        string   `json:"jwks_url"`
+	Algorithms     []string `json:"algorithms"`
+	RequiredClaims []string `json:"required_claims"`
+	ScopesClaim    string   `json:"scopes_claim"`
+	StrictProfile  bool     `json:"strict_profile"`
+	HeaderKeyUrls  []string `json:"header_key_urls"`
+
+	ValidateCertificates bool `json:"validate_certificates"`
+	RequireDpop          bool `json:"require_dpop"`
+	RequireMtls          bool `json:"require_mtls"`
+
+	JwksFile       string          `json:"jwks_file"`
+	Jwks           json.RawMessage `json:"jwks"`
+	PinnedKeysFile string          `json:"pinned_keys_file"`
+	Offline        bool            `json:"offline"`
+
+	HmacSecrets []HmacSecretConfig `json:"hmac_secrets"`
+}
+
+// HmacSecretConfig is a base64url encoded shared secret for
+// HS256/HS384/HS512 tokens, the audience must be the audience of the
+// issuer and defaults to it
+type HmacSecretConfig struct {
+	Audience string `json:"audience"`
+	Secret   string `json:"secret"`
+}
+
 var (
 	MgmtApiClientId     string
 	MgmtApiClientSecret string
@@ -24,17 +61,73 @@ var (
 )
 
 var (
-	TokenAudience string
-	TokenIssuer   string
+	TokenAudience       string
+	TokenIssuer         string
+	TokenJwksUrl        string
+	TokenLeeway         time.Duration
+	TokenMaxAge         time.Duration
+	TokenRequiredClaims []string
+	TokenAlgorithms     []string
+	TokenProfileStrict  bool
+	TokenIssuers        []TokenIssuerConfig
+
+	TokenDecryptionKeyFile string
+	TokenDecryptionSecret  string
+	TokenHeaderKeyUrls     []string
+	TokenTrustAnchorFile   string
+
+	TokenCertificateRootFile  string
+	TokenValidateCertificates bool
+
+	TokenIntrospectionUrl          string
+	TokenIntrospectionClientId     string
+	TokenIntrospectionClientSecret string
+	TokenIntrospectionAudience     string
+
+	TokenRequireDpop bool
+	TokenRequireMtls bool
+
+	TokenJwksFile       string
+	TokenJwksInline     string
+	TokenPinnedKeysFile string
+	TokenJwksOffline    bool
+
+	TokenHmacSecrets []string
+)
+
+var (
+	DpopEnabled       bool
+	DpopNonceRotation time.Duration
+	DpopProofMaxAge   time.Duration
+	DpopPublicUrl     string
+)
+
+var (
+	SigningAlg         string
+	SigningKeyFile     string
+	SigningKeyGrace    time.Duration
+	SigningKeyRotation time.Duration
+)
+
+var (
+	MtlsProxyHeader    string
+	MtlsTrustedProxies []string
 )
 
 var (
 	Host string
 	Mode string
 	Port string
+
+	TlsCertFile     string
+	TlsKeyFile      string
+	TlsClientAuth   string
+	TlsClientCaFile string
 )
 
-func Load() {
+// Load reads the configuration from the environment, an error is returned
+// for settings that cannot be parsed
+func Load() error {
 	godotenv.Load(".env.local")
 	godotenv.Load()
 
@@ -52,8 +145,124 @@ func Load() {
 
 	TokenAudience = os.Getenv("TOKEN_AUDIENCE")
 	TokenIssuer = os.Getenv("TOKEN_ISSUER")
+	TokenJwksUrl = os.Getenv("TOKEN_JWKS_URL")
+	TokenLeeway = getEnvDuration("TOKEN_LEEWAY", DefaultTokenLeeway)
+	TokenMaxAge = getEnvDuration("TOKEN_MAX_AGE", 0)
+	TokenRequiredClaims = getEnvList("TOKEN_REQUIRED_CLAIMS")
+	TokenAlgorithms = getEnvList("TOKEN_ALGORITHMS")
+	TokenProfileStrict = getEnvBool("TOKEN_PROFILE_STRICT")
+
+	var err error
+	TokenIssuers, err = getEnvTokenIssuers("TOKEN_ISSUERS")
+	if err != nil {
+		return err
+	}
+
+	TokenDecryptionKeyFile = os.Getenv("TOKEN_DECRYPTION_KEY_FILE")
+	TokenDecryptionSecret = os.Getenv("TOKEN_DECRYPTION_SECRET")
+	TokenHeaderKeyUrls = getEnvList("TOKEN_HEADER_KEY_URLS")
+	TokenTrustAnchorFile = os.Getenv("TOKEN_TRUST_ANCHOR_FILE")
+	TokenCertificateRootFile = os.Getenv("TOKEN_CERTIFICATE_ROOT_FILE")
+	TokenValidateCertificates = getEnvBool("TOKEN_VALIDATE_CERTIFICATES")
+	TokenIntrospectionUrl = os.Getenv("TOKEN_INTROSPECTION_URL")
+	TokenIntrospectionClientId = os.Getenv("TOKEN_INTROSPECTION_CLIENT_ID")
+	TokenIntrospectionClientSecret = os.Getenv("TOKEN_INTROSPECTION_CLIENT_SECRET")
+	TokenIntrospectionAudience = os.Getenv("TOKEN_INTROSPECTION_AUDIENCE")
+	TokenRequireDpop = getEnvBool("TOKEN_REQUIRE_DPOP")
+	TokenRequireMtls = getEnvBool("TOKEN_REQUIRE_MTLS")
+	TokenJwksFile = os.Getenv("TOKEN_JWKS_FILE")
+	TokenJwksInline = os.Getenv("TOKEN_JWKS_INLINE")
+	TokenPinnedKeysFile = os.Getenv("TOKEN_PINNED_KEYS_FILE")
+	TokenJwksOffline = getEnvBool("TOKEN_JWKS_OFFLINE")
+	TokenHmacSecrets = getEnvList("TOKEN_HMAC_SECRETS")
+
+	DpopEnabled = getEnvBool("DPOP_ENABLED") || TokenRequireDpop
+	for _, issuer := range TokenIssuers {
+		DpopEnabled = DpopEnabled || issuer.RequireDpop
+	}
+
+	DpopNonceRotation = getEnvDuration("DPOP_NONCE_ROTATION", 0)
+	DpopProofMaxAge = getEnvDuration("DPOP_PROOF_MAX_AGE", DefaultDpopProofMaxAge)
+	DpopPublicUrl = os.Getenv("DPOP_PUBLIC_URL")
+
+	MtlsProxyHeader = os.Getenv("MTLS_PROXY_HEADER")
+	MtlsTrustedProxies = getEnvList("MTLS_TRUSTED_PROXIES")
+
+	SigningAlg = getEnvString("SIGNING_ALG", DefaultSigningAlg)
+	SigningKeyFile = os.Getenv("SIGNING_KEY_FILE")
+	SigningKeyGrace = getEnvDuration("SIGNING_KEY_GRACE", DefaultSigningKeyGrace)
+	SigningKeyRotation = getEnvDuration("SIGNING_KEY_ROTATION", DefaultSigningKeyRotation)
 
 	Host = os.Getenv("HOST")
 	Mode = os.Getenv("MODE")
 	Port = os.Getenv("PORT")
+
+	TlsCertFile = os.Getenv("TLS_CERT_FILE")
+	TlsKeyFile = os.Getenv("TLS_KEY_FILE")
+	TlsClientAuth = os.Getenv("TLS_CLIENT_AUTH")
+	TlsClientCaFile = os.Getenv("TLS_CLIENT_CA_FILE")
+
+	return nil
+}
+
+func getEnvBool(key string) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return false
+	}
+
+	return value
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+func getEnvString(key string, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	return value
+}
+
+// getEnvTokenIssuers fails on a malformed value, starting without the
+// issuers would reject their tokens instead of reporting the error
+func getEnvTokenIssuers(key string) ([]TokenIssuerConfig, error) {
+	issuers := []TokenIssuerConfig{}
+
+	value := os.Getenv(key)
+	if value == "" {
+		return issuers, nil
+	}
+
+	if err := json.Unmarshal([]byte(value), &issuers); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", key, err)
+	}
+
+	for i, issuer := range issuers {
+		if issuer.Issuer == "" {
+			return nil, fmt.Errorf("parsing %s: issuer %d has no issuer", key, i)
+		}
+	}
+
+	return issuers, nil
+}
+
+func getEnvList(key string) []string {
+	values := []string{}
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
 }
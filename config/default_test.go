@@ -0,0 +1,30 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestGetEnvTokenIssuers(t *testing.T) {
+	testCases := []struct {
+		name  string
+		value string
+		want  int
+		valid bool
+	}{
+		{"unset", "", 0, true},
+		{"issuers", `[{"issuer":"https://a/","audience":"api"},{"issuer":"https://a/","audience":"other"}]`, 2, true},
+		{"malformed", `[{"issuer":"https://a/"`, 0, false},
+		{"missing issuer", `[{"audience":"api"}]`, 0, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("TOKEN_ISSUERS", tc.value)
+
+			issuers, err := getEnvTokenIssuers("TOKEN_ISSUERS")
+			if tc.valid != (err == nil) || len(issuers) != tc.want {
+				t.Fatalf(`getEnvTokenIssuers() = %v, %v, want match for %d issuers, valid %t`, issuers, err, tc.want, tc.valid)
+			}
+		})
+	}
+}
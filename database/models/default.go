@@ -23,3 +23,17 @@ type User struct {
 	CreatedAt     time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
 	UpdatedAt     time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
 }
+
+// Revocation denies a token by its jti, or every token of a subject
+// issued before IssuedBefore, until ExpiresAt when the revoked tokens
+// would have expired anyway
+type Revocation struct {
+	Id           primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Jti          string             `bson:"jti,omitempty" json:"jti,omitempty" validate:"required_without=Sub"`
+	Sub          string             `bson:"sub,omitempty" json:"sub,omitempty" validate:"required_without=Jti"`
+	Iss          string             `bson:"iss,omitempty" json:"iss,omitempty"`
+	IssuedBefore time.Time          `bson:"issued_before,omitempty" json:"issued_before,omitempty"`
+	ExpiresAt    time.Time          `bson:"expires_at,omitempty" json:"expires_at,omitempty" validate:"required"`
+	Reason       string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log"
 	"net/http"
@@ -22,7 +24,9 @@ func main() {
 	log.SetPrefix("> ")
 
 	// setup env
-	config.Load()
+	if err := config.Load(); err != nil {
+		log.Fatalf("Error loading config: %s\n", err)
+	}
 
 	// blank engine
 	router := gin.New()
@@ -44,6 +48,7 @@ func main() {
 		IdleTimeout:  config.DefaultIdleTimeout,
 		ReadTimeout:  config.DefaultReadTimeout,
 		WriteTimeout: config.DefaultWriteTimeout,
+		TLSConfig:    serverTlsConfig(),
 	}
 
 	go func() {
@@ -53,7 +58,7 @@ func main() {
 		}
 
 		log.Printf("Starting server on %s", config.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := listenAndServe(srv); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Listen: %s\n", err)
 		}
 	}()
@@ -72,3 +77,48 @@ func main() {
 		log.Print("Server forced to shutdown: ", err)
 	}
 }
+
+func listenAndServe(srv *http.Server) error {
+	if config.TlsCertFile == "" {
+		return srv.ListenAndServe()
+	}
+
+	return srv.ListenAndServeTLS(config.TlsCertFile, config.TlsKeyFile)
+}
+
+// serverTlsConfig optionally requests client certificates, "request"
+// accepts self-signed certificates bound by thumbprint only (RFC 8705),
+// "verify" also verifies them against the client CA file
+func serverTlsConfig() *tls.Config {
+	if config.TlsCertFile == "" {
+		return nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	switch config.TlsClientAuth {
+	case "":
+		tlsConfig.ClientAuth = tls.NoClientCert
+	case "request":
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	case "verify":
+		pemData, err := os.ReadFile(config.TlsClientCaFile)
+		if err != nil {
+			log.Fatalf("Error reading client CA file: %s\n", err)
+		}
+
+		clientCas := x509.NewCertPool()
+		if !clientCas.AppendCertsFromPEM(pemData) {
+			log.Fatalf("Error parsing client CA file: no certificates found\n")
+		}
+
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		tlsConfig.ClientCAs = clientCas
+	default:
+		log.Fatalf("Unknown TLS client auth: %s\n", config.TlsClientAuth)
+	}
+
+	return tlsConfig
+}
@@ -1,32 +1,268 @@
 package middleware
 
 import (
+	"fmt"
+	"time"
+
 	"dahbura.me/api/config"
 	"dahbura.me/api/security/jose"
+	"dahbura.me/api/security/oauth2/introspection"
+	"dahbura.me/api/security/oidc"
+	"dahbura.me/api/security/revocation"
 	httppkg "dahbura.me/api/util/http"
 
 	"github.com/gin-gonic/gin"
 )
 
 type CheckJwtOpts struct {
-	TokenAudience string
-	TokenIssuer   string
+	Issuers []TrustedIssuer
+
+	// DecryptionKeys decrypt nested JWTs (a JWS inside a JWE)
+	DecryptionKeys []jose.DecryptionKey
+
+	// Introspector resolves opaque (non JWT) tokens, they are rejected
+	// when nil
+	Introspector *introspection.Introspector
+
+	// Dpop accepts DPoP bound tokens, they are rejected when nil
+	Dpop *DpopOpts
+
+	// Mtls accepts certificate-bound tokens, they are rejected when nil
+	Mtls *MtlsOpts
+
+	// Revocations deny-lists tokens before they expire
+	Revocations *revocation.Store
+}
+
+// TrustedIssuer describes how tokens from one issuer are verified, an
+// issuer may be trusted several times for different audiences (e.g. an
+// RS256 API and an HS256 API of the same tenant)
+type TrustedIssuer struct {
+	TokenAudience  string
+	TokenIssuer    string
+	JwksUrl        string
+	Leeway         time.Duration
+	MaxTokenAge    time.Duration
+	RequiredClaims []string
+	Algorithms     []string
+	ScopesClaim    string
+	HeaderKeys     *jose.HeaderKeyPolicy
+	Certificates   *jose.CertificatePolicy
+
+	// StrictProfile requires RFC 9068 access tokens (typ "at+jwt")
+	StrictProfile bool
+
+	// RequireDpop rejects tokens that are not DPoP bound
+	RequireDpop bool
+
+	// RequireMtls rejects tokens that are not bound to a client certificate
+	RequireMtls bool
+
+	// Keys are local key sources (file, inline or pinned keys) tried
+	// before the JWKS URL, Offline never falls back to the network
+	Keys    jose.KeySource
+	Offline bool
+
+	// Secrets make the issuer HMAC only, tokens are verified with a shared
+	// secret and never with its keys
+	Secrets []jose.HmacSecret
+}
+
+// trustedIssuer is a TrustedIssuer with its combined key source
+type trustedIssuer struct {
+	TrustedIssuer
+	keys jose.KeySource
 }
 
+// CheckJwt verifies the tokens of opts.Issuers, each issuer must appear
+// once per audience
 func CheckJwt(opts CheckJwtOpts) func() gin.HandlerFunc {
+	issuers := map[string][]trustedIssuer{}
+	for _, issuer := range opts.Issuers {
+		issuers[issuer.TokenIssuer] = append(issuers[issuer.TokenIssuer], trustedIssuer{
+			TrustedIssuer: issuer,
+			keys:          issuerKeySource(issuer),
+		})
+	}
+
+	dpop := newDpopChecker(opts.Dpop)
+
 	return func() gin.HandlerFunc {
 		return func(c *gin.Context) {
-			token, err := httppkg.TokenFromHeader(c)
-			if httppkg.HandleErrorMiddleware(c, err) {
+			scheme, token, err := httppkg.AuthorizationFromHeader(c)
+			if err == nil && scheme == "DPoP" && dpop == nil {
+				err = errDpopUnsupported
+			}
+			if abortWithTokenError(c, err) {
+				return
+			}
+
+			if opts.Introspector != nil && !jose.IsCompactJws(token) && !jose.IsCompactJwe(token) {
+				claims, err := opts.Introspector.Introspect(token)
+				if abortWithTokenError(c, err) {
+					return
+				}
+
+				err = dpop.checkDpop(c, scheme, token, claims, false)
+				if abortWithTokenError(c, err) {
+					return
+				}
+
+				err = checkCertificateBinding(c, opts.Mtls, claims, false)
+				if abortWithTokenError(c, err) {
+					return
+				}
+
+				if opts.Revocations != nil && opts.Revocations.IsRevoked(claims) {
+					abortWithTokenError(c, revocation.ErrRevoked)
+					return
+				}
+
+				c.Set(config.ContextBearerToken, token)
+				c.Set(config.ContextClaims, claims)
+				c.Set(config.ContextScopesClaim, "scope")
+				return
+			}
+
+			jws := token
+			if jose.IsCompactJwe(token) {
+				jws, err = jose.DecryptNested(token, opts.DecryptionKeys)
+				if abortWithTokenError(c, err) {
+					return
+				}
+			}
+
+			// the unverified iss only selects the issuer, unknown issuers
+			// are rejected before any key or discovery request is made
+			issuer, err := selectIssuer(issuers, jws)
+			if abortWithTokenError(c, err) {
+				return
+			}
+
+			verifyOpts := jose.VerifyOpts{
+				Issuer:             issuer.TokenIssuer,
+				Keys:               issuer.keys,
+				Audience:           issuer.TokenAudience,
+				Leeway:             issuer.Leeway,
+				MaxAge:             issuer.MaxTokenAge,
+				RequiredClaims:     issuer.RequiredClaims,
+				Algorithms:         issuer.Algorithms,
+				AccessTokenProfile: issuer.StrictProfile,
+				HeaderKeys:         issuer.HeaderKeys,
+				Certificates:       issuer.Certificates,
+				Secrets:            issuer.Secrets,
+			}
+
+			claims, err := jose.VerifyCompact(jws, verifyOpts)
+			if abortWithTokenError(c, err) {
+				return
+			}
+
+			err = dpop.checkDpop(c, scheme, token, claims, issuer.RequireDpop)
+			if abortWithTokenError(c, err) {
 				return
 			}
 
-			err = jose.VerifyCompact(token, opts.TokenIssuer, opts.TokenAudience)
-			if httppkg.HandleErrorMiddleware(c, err) {
+			err = checkCertificateBinding(c, opts.Mtls, claims, issuer.RequireMtls)
+			if abortWithTokenError(c, err) {
+				return
+			}
+
+			if opts.Revocations != nil && opts.Revocations.IsRevoked(claims) {
+				abortWithTokenError(c, revocation.ErrRevoked)
 				return
 			}
 
 			c.Set(config.ContextBearerToken, token)
+			c.Set(config.ContextClaims, claims)
+			c.Set(config.ContextScopesClaim, issuer.ScopesClaim)
+		}
+	}
+}
+
+// selectIssuer picks the trusted issuer of the token by its unverified iss
+// and, when the issuer is trusted for several audiences, its unverified
+// aud. The token is then verified against that issuer only
+func selectIssuer(issuers map[string][]trustedIssuer, jws string) (*trustedIssuer, error) {
+	iss, err := jose.UnverifiedIssuer(jws)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := issuers[iss]
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w: untrusted issuer %s", jose.ErrInvalidIssuer, iss)
+	}
+
+	if len(candidates) == 1 {
+		return &candidates[0], nil
+	}
+
+	aud, audErr := jose.UnverifiedAudience(jws)
+
+	for i, candidate := range candidates {
+		for _, a := range aud {
+			if a == candidate.TokenAudience {
+				return &candidates[i], nil
+			}
 		}
 	}
+
+	// an entry without audience accepts the tokens of any other audience
+	for i, candidate := range candidates {
+		if candidate.TokenAudience == "" {
+			return &candidates[i], nil
+		}
+	}
+
+	if audErr != nil {
+		return nil, audErr
+	}
+
+	return nil, fmt.Errorf("%w: untrusted audience for issuer %s", jose.ErrInvalidAudience, iss)
+}
+
+// issuerKeySource tries the local key sources of the issuer first and then,
+// unless offline, its JWKS URL
+func issuerKeySource(issuer TrustedIssuer) jose.KeySource {
+	sources := []jose.KeySource{}
+	if issuer.Keys != nil {
+		sources = append(sources, issuer.Keys)
+	}
+
+	if !issuer.Offline {
+		sources = append(sources, &discoveryKeySource{issuer: issuer})
+	}
+
+	return jose.KeySources(sources...)
+}
+
+// discoveryKeySource resolves the JWKS URL on first use so discovery is
+// only requested once a token of the issuer is seen
+type discoveryKeySource struct {
+	issuer TrustedIssuer
+}
+
+func (source *discoveryKeySource) Key(kid string) (*jose.Jwk, error) {
+	jwksUrl, err := resolveJwksUrl(source.issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return jose.NewUrlKeySource(jwksUrl).Key(kid)
+}
+
+// resolveJwksUrl returns the explicit JWKS URL when configured, otherwise
+// the jwks_uri published in the issuer discovery document
+func resolveJwksUrl(issuer TrustedIssuer) (string, error) {
+	if issuer.JwksUrl != "" {
+		return issuer.JwksUrl, nil
+	}
+
+	providerConfig, err := oidc.GetOpenIdProviderConfig(issuer.TokenIssuer)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", jose.ErrKeyUnavailable, err)
+	}
+
+	return providerConfig.JwksUri, nil
 }
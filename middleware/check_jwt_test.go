@@ -0,0 +1,226 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dahbura.me/api/security/jose"
+	"dahbura.me/api/security/oauth2/introspection"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testIssuer = "https://issuer/"
+
+// testTokens signs access tokens for testIssuer and serves its key
+type testTokens struct {
+	key  *jose.SigningKey
+	keys jose.KeySource
+}
+
+func newTestTokens(t *testing.T) *testTokens {
+	key, err := jose.GenerateSigningKey("ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk, err := jose.NewJwk(key.Key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk.Kid = key.Kid
+
+	return &testTokens{key: key, keys: jose.NewStaticKeySource(&jose.JwkSet{Keys: []jose.Jwk{*jwk}})}
+}
+
+func (tokens *testTokens) issuer() TrustedIssuer {
+	return TrustedIssuer{
+		TokenIssuer:   testIssuer,
+		TokenAudience: "api",
+		ScopesClaim:   "permissions",
+		Keys:          tokens.keys,
+		Offline:       true,
+	}
+}
+
+func (tokens *testTokens) sign(t *testing.T, exp time.Duration, custom map[string]interface{}) string {
+	claims := jose.Claims{
+		Iss:    testIssuer,
+		Sub:    "auth0|1",
+		Aud:    []string{"api"},
+		Exp:    time.Now().Add(exp).Unix(),
+		Iat:    time.Now().Unix(),
+		Jti:    "jti",
+		Custom: custom,
+	}
+
+	token, err := jose.SignCompact(&claims, tokens.key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+// serveTest runs the request through CheckJwt and CheckScope("read:users")
+func serveTest(opts CheckJwtOpts, req *http.Request) *httptest.ResponseRecorder {
+	checkJwt := CheckJwt(opts)
+	checkScope := CheckScope(CheckScopeOpts{ScopesClaim: "permissions"})
+
+	return serveHandlers(req, checkJwt(), checkScope("read:users"))
+}
+
+func serveHandlers(req *http.Request, handlers ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/users", append(handlers, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})...)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestCheckJwtErrors(t *testing.T) {
+	tokens := newTestTokens(t)
+
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer unavailable.Close()
+
+	introspector, err := introspection.NewIntrospector(introspection.IntrospectorOpts{
+		Url:          unavailable.URL,
+		ClientId:     "client",
+		ClientSecret: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outage := TrustedIssuer{
+		TokenIssuer:   "https://outage/",
+		TokenAudience: "api",
+		JwksUrl:       unavailable.URL,
+	}
+
+	opts := CheckJwtOpts{
+		Issuers:      []TrustedIssuer{tokens.issuer(), outage},
+		Introspector: introspector,
+	}
+
+	outageToken, err := jose.SignCompact(&jose.Claims{
+		Iss: "https://outage/",
+		Aud: []string{"api"},
+		Exp: time.Now().Add(time.Hour).Unix(),
+	}, tokens.key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	permissions := func(scopes ...string) map[string]interface{} {
+		return map[string]interface{}{"permissions": scopes}
+	}
+
+	testCases := []struct {
+		name          string
+		authorization string
+		status        int
+		challenge     string
+	}{
+		{"valid", "Bearer " + tokens.sign(t, time.Hour, permissions("read:users")), http.StatusOK, ""},
+		{"missing header", "", http.StatusUnauthorized, "Bearer"},
+		{"malformed header", "Bearer a b", http.StatusBadRequest, `Bearer error="invalid_request"`},
+		{"expired token", "Bearer " + tokens.sign(t, -time.Hour, permissions("read:users")), http.StatusUnauthorized, `Bearer error="invalid_token"`},
+		{"missing scope", "Bearer " + tokens.sign(t, time.Hour, permissions("read:clients")), http.StatusForbidden, `Bearer error="insufficient_scope"`},
+		{"keys unavailable", "Bearer " + outageToken, http.StatusServiceUnavailable, ""},
+		{"introspection unavailable", "Bearer opaque-token", http.StatusServiceUnavailable, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+
+			w := serveTest(opts, req)
+			challenge := w.Header().Get("WWW-Authenticate")
+
+			if w.Code != tc.status {
+				t.Fatalf(`status = %d, want match for %d`, w.Code, tc.status)
+			}
+
+			if tc.challenge == "" && challenge != "" || !strings.HasPrefix(challenge, tc.challenge) {
+				t.Fatalf(`WWW-Authenticate = %q, want match for %q`, challenge, tc.challenge)
+			}
+
+			if tc.challenge == "Bearer" && challenge != "Bearer" {
+				t.Fatalf(`WWW-Authenticate = %q, want match for bare Bearer`, challenge)
+			}
+		})
+	}
+}
+
+func TestCheckJwtInsufficientScopeChallenge(t *testing.T) {
+	tokens := newTestTokens(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.sign(t, time.Hour, map[string]interface{}{"permissions": []string{}}))
+
+	w := serveTest(CheckJwtOpts{Issuers: []TrustedIssuer{tokens.issuer()}}, req)
+
+	challenge := w.Header().Get("WWW-Authenticate")
+	if w.Code != http.StatusForbidden || !strings.Contains(challenge, `scope="read:users"`) {
+		t.Fatalf(`CheckScope() = %d, %q, want match for 403 with scope="read:users"`, w.Code, challenge)
+	}
+}
+
+func TestSelectIssuer(t *testing.T) {
+	encoder := base64.RawURLEncoding.EncodeToString
+	header := encoder([]byte(`{"alg":"HS256"}`))
+	token := func(payload string) string {
+		return header + "." + encoder([]byte(payload)) + ".sig"
+	}
+
+	issuers := map[string][]trustedIssuer{
+		"https://tenant/": {
+			{TrustedIssuer: TrustedIssuer{TokenIssuer: "https://tenant/", TokenAudience: "rs256-api"}},
+			{TrustedIssuer: TrustedIssuer{TokenIssuer: "https://tenant/", TokenAudience: "hs256-api"}},
+		},
+		"https://single/": {
+			{TrustedIssuer: TrustedIssuer{TokenIssuer: "https://single/", TokenAudience: "api"}},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		token    string
+		audience string
+		want     error
+	}{
+		{"first audience", token(`{"iss":"https://tenant/","aud":"rs256-api"}`), "rs256-api", nil},
+		{"second audience", token(`{"iss":"https://tenant/","aud":["other","hs256-api"]}`), "hs256-api", nil},
+		{"single issuer", token(`{"iss":"https://single/","aud":"other"}`), "api", nil},
+		{"untrusted audience", token(`{"iss":"https://tenant/","aud":"other"}`), "", jose.ErrInvalidAudience},
+		{"missing audience", token(`{"iss":"https://tenant/"}`), "", jose.ErrMissingClaim},
+		{"untrusted issuer", token(`{"iss":"https://other/","aud":"api"}`), "", jose.ErrInvalidIssuer},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			issuer, err := selectIssuer(issuers, tc.token)
+			if !errors.Is(err, tc.want) || (err == nil && issuer.TokenAudience != tc.audience) {
+				t.Fatalf(`selectIssuer() = %v, %v, want match for %s, %v`, issuer, err, tc.audience, tc.want)
+			}
+		})
+	}
+}
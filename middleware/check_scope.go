@@ -1,27 +1,54 @@
 package middleware
 
 import (
-	httppkg "dahbura.me/api/util/http"
+	"fmt"
+
+	"dahbura.me/api/config"
 
 	"github.com/gin-gonic/gin"
 )
 
 type CheckScopeOpts struct {
+	// ScopesClaim is used when the trusted issuer of the token does not
+	// configure its own
 	ScopesClaim string
 }
 
 func CheckScope(opts CheckScopeOpts) func(string) gin.HandlerFunc {
 	return func(scope string) gin.HandlerFunc {
 		return func(c *gin.Context) {
-			token, err := httppkg.TokenFromContext(c)
-			if httppkg.HandleErrorMiddleware(c, err) {
+			claims, err := ClaimsFromContext(c)
+			if abortWithTokenError(c, err) {
+				return
+			}
+
+			scopesClaim := c.GetString(config.ContextScopesClaim)
+			if scopesClaim == "" {
+				scopesClaim = opts.ScopesClaim
+			}
+
+			scopes, ok := claims.Strings(scopesClaim)
+			if !ok {
+				err = fmt.Errorf("scopes claim not found: %s", scopesClaim)
+				abortWithInsufficientScope(c, scope, err)
 				return
 			}
 
-			err = httppkg.VerifyScope(token, opts.ScopesClaim, scope)
-			if httppkg.HandleErrorMiddleware(c, err) {
+			if !hasScope(scopes, scope) {
+				err = fmt.Errorf("scope not found: %s", scope)
+				abortWithInsufficientScope(c, scope, err)
 				return
 			}
 		}
 	}
 }
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
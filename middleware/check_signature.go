@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"dahbura.me/api/config"
+	"dahbura.me/api/security/jose"
+	httppkg "dahbura.me/api/util/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CheckSignatureOpts struct {
+	// Header carrying the detached JWS, DefaultSignatureHeader when empty
+	Header     string
+	Issuer     string
+	JwksUrl    string
+	Algorithms []string
+	// MaxBodySize read for verification, DefaultMaxBodySize when 0
+	MaxBodySize int64
+}
+
+// CheckSignature verifies a detached JWS carried in a request header
+// against the raw request body, the body is restored for the handlers.
+// Routes does not mount it, it is meant for routes receiving signed
+// requests such as webhooks. Bodies over MaxBodySize are refused with 413
+func CheckSignature(opts CheckSignatureOpts) func() gin.HandlerFunc {
+	header := opts.Header
+	if header == "" {
+		header = config.DefaultSignatureHeader
+	}
+
+	maxBodySize := opts.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = config.DefaultMaxBodySize
+	}
+
+	return func() gin.HandlerFunc {
+		return func(c *gin.Context) {
+			jws := c.GetHeader(header)
+			if jws == "" {
+				httppkg.HandleErrorMiddleware(c, errors.New("signature header not found"))
+				return
+			}
+
+			// one byte past the limit tells an oversized body apart
+			body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBodySize+1))
+			if httppkg.HandleErrorMiddleware(c, err) {
+				return
+			}
+
+			if int64(len(body)) > maxBodySize {
+				err = errors.New("request body too large")
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"msg": err.Error()})
+				c.Error(err)
+				return
+			}
+
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+			verifyOpts := jose.VerifyOpts{
+				Issuer:     opts.Issuer,
+				JwksUrl:    opts.JwksUrl,
+				Algorithms: opts.Algorithms,
+			}
+
+			_, err = jose.VerifyDetached(jws, body, verifyOpts)
+			if httppkg.HandleErrorMiddleware(c, err) {
+				return
+			}
+		}
+	}
+}
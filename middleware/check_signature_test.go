@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dahbura.me/api/config"
+	"dahbura.me/api/security/jose"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckSignature(t *testing.T) {
+	key, err := jose.GenerateSigningKey("ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk, _ := jose.NewJwk(key.Key.Public())
+	jwk.Kid = key.Kid
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JwkSet{Keys: []jose.Jwk{*jwk}})
+	}))
+	defer ts.Close()
+
+	body := `{"event":"user.created"}`
+	signature, err := jose.SignDetached([]byte(body), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkSignature := CheckSignature(CheckSignatureOpts{
+		Issuer:      ts.URL + "/",
+		JwksUrl:     ts.URL,
+		MaxBodySize: int64(len(body)),
+	})
+
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.POST("/webhooks", checkSignature(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	testCases := []struct {
+		name      string
+		body      string
+		signature string
+		status    int
+	}{
+		{"valid", body, signature, http.StatusOK},
+		{"missing signature", body, "", http.StatusUnauthorized},
+		{"modified body", strings.Replace(body, "created", "deleted", 1), signature, http.StatusUnauthorized},
+		{"body too large", body + " ", signature, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(tc.body))
+			if tc.signature != "" {
+				req.Header.Set(config.DefaultSignatureHeader, tc.signature)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Fatalf(`CheckSignature() = %d, want match for %d`, w.Code, tc.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"errors"
+
+	"dahbura.me/api/config"
+	"dahbura.me/api/security/jose"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClaimsFromContext returns the claims verified by CheckJwt
+func ClaimsFromContext(c *gin.Context) (*jose.Claims, error) {
+	claimsValue, exists := c.Get(config.ContextClaims)
+	if !exists {
+		return nil, errors.New("unable to find claims")
+	}
+
+	claims, ok := claimsValue.(*jose.Claims)
+	if !ok {
+		return nil, errors.New("unable to convert claims")
+	}
+
+	return claims, nil
+}
+
+// SubjectFromContext returns the verified "sub" claim
+func SubjectFromContext(c *gin.Context) (string, error) {
+	claims, err := ClaimsFromContext(c)
+	if err != nil {
+		return "", err
+	}
+
+	return claims.Sub, nil
+}
+
+// ClaimFromContext returns the named verified custom claim, such as
+// "azp", "org_id" or a namespaced claim
+func ClaimFromContext(c *gin.Context, name string) (interface{}, bool) {
+	claims, err := ClaimsFromContext(c)
+	if err != nil {
+		return nil, false
+	}
+
+	return claims.Get(name)
+}
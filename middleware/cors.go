@@ -16,8 +16,13 @@ func newCorsConfig() cors.Config {
 		"Authorization",
 		"Content-Length",
 		"Content-Type",
+		"DPoP",
 		"Origin",
 	}
+	config.ExposeHeaders = []string{
+		"DPoP-Nonce",
+		"WWW-Authenticate",
+	}
 
 	return config
 }
@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"dahbura.me/api/security/jose"
+	"dahbura.me/api/util/cache"
+	httppkg "dahbura.me/api/util/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DpopOpts enables DPoP (RFC 9449) sender-constrained access tokens
+type DpopOpts struct {
+	// PublicUrl is the external scheme and host of the API proofs refer
+	// to in "htu", it is derived from the request when empty
+	PublicUrl string
+
+	// ProofMaxAge bounds how long a proof, and its jti, is accepted
+	ProofMaxAge time.Duration
+	Leeway      time.Duration
+	Algorithms  []string
+
+	// NonceRotation requires server issued nonces in proofs, rotated at
+	// this interval, 0 disables nonces
+	NonceRotation time.Duration
+}
+
+// errDpopUnsupported answers the DPoP scheme when DPoP is not configured,
+// as any other unsupported scheme with a plain Bearer challenge
+var errDpopUnsupported = fmt.Errorf("%w: dpop not supported", httppkg.ErrAuthorizationMissing)
+
+type dpopChecker struct {
+	opts   DpopOpts
+	replay *cache.MemoryCache
+	nonces *nonceSource
+}
+
+// nonceSource issues the current DPoP nonce, the previous one is still
+// accepted so clients are not rejected right after a rotation
+type nonceSource struct {
+	rotation  time.Duration
+	current   string
+	previous  string
+	rotatedAt time.Time
+	mtx       sync.Mutex
+}
+
+func newDpopChecker(opts *DpopOpts) *dpopChecker {
+	if opts == nil {
+		return nil
+	}
+
+	checker := dpopChecker{
+		opts:   *opts,
+		replay: cache.New(),
+	}
+
+	if opts.NonceRotation > 0 {
+		checker.nonces = &nonceSource{rotation: opts.NonceRotation}
+	}
+
+	return &checker
+}
+
+// checkDpop enforces the key binding of the token, a DPoP bound token
+// ("cnf.jkt") requires a valid proof of possession of that key
+func (dc *dpopChecker) checkDpop(c *gin.Context, scheme string, token string, claims *jose.Claims, required bool) error {
+	jkt, bound := claims.Confirmation("jkt")
+
+	if scheme != "DPoP" {
+		if bound {
+			return dc.challenge(c, "invalid_token", errors.New("dpop bound token presented as bearer"))
+		}
+
+		if required {
+			return dc.challenge(c, "invalid_token", errors.New("dpop bound token required"))
+		}
+
+		return nil
+	}
+
+	if dc == nil {
+		return errDpopUnsupported
+	}
+
+	proofs := c.Request.Header.Values("DPoP")
+	if len(proofs) != 1 {
+		return dc.challenge(c, "invalid_dpop_proof", errors.New("exactly one dpop proof required"))
+	}
+
+	verifyOpts := jose.DpopOpts{
+		Method:      c.Request.Method,
+		Url:         dc.requestUrl(c),
+		AccessToken: token,
+		MaxAge:      dc.opts.ProofMaxAge,
+		Leeway:      dc.opts.Leeway,
+		Algorithms:  dc.opts.Algorithms,
+	}
+
+	if dc.nonces != nil {
+		verifyOpts.Nonces = dc.nonces.valid()
+		c.Header("DPoP-Nonce", verifyOpts.Nonces[0])
+	}
+
+	proof, err := jose.VerifyDpopProof(proofs[0], verifyOpts)
+	if err == jose.ErrDpopNonce {
+		return dc.challenge(c, "use_dpop_nonce", err)
+	}
+	if err != nil {
+		return dc.challenge(c, "invalid_dpop_proof", err)
+	}
+
+	// a proof is single use, its jti is remembered for as long as the
+	// proof itself would be accepted
+	item := cache.Item{Key: proof.Jkt + ":" + proof.Claims.Jti}
+	itemPolicy := cache.ItemPolicy{AbsoluteExp: proof.Claims.IssuedAt().Add(dc.opts.ProofMaxAge + dc.opts.Leeway*2)}
+	if !dc.replay.Add(item, itemPolicy) {
+		return dc.challenge(c, "invalid_dpop_proof", errors.New("dpop proof replayed"))
+	}
+
+	if !bound {
+		return dc.challenge(c, "invalid_token", errors.New("token is not dpop bound"))
+	}
+
+	if jkt != proof.Jkt {
+		return dc.challenge(c, "invalid_token", errors.New("dpop proof key does not match token binding"))
+	}
+
+	return nil
+}
+
+func (dc *dpopChecker) challenge(c *gin.Context, code string, err error) error {
+	if dc != nil {
+		algs := dc.opts.Algorithms
+		if len(algs) == 0 {
+			algs = jose.DefaultAlgorithms
+		}
+
+		c.Header("WWW-Authenticate", fmt.Sprintf(`DPoP error="%s", algs="%s"`, code, strings.Join(algs, " ")))
+	}
+
+	return err
+}
+
+func (dc *dpopChecker) requestUrl(c *gin.Context) string {
+	baseUrl := strings.TrimSuffix(dc.opts.PublicUrl, "/")
+	if baseUrl == "" {
+		scheme := "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+
+		baseUrl = scheme + "://" + c.Request.Host
+	}
+
+	return baseUrl + c.Request.URL.EscapedPath()
+}
+
+// valid returns the nonces currently accepted, the current one first
+func (ns *nonceSource) valid() []string {
+	ns.mtx.Lock()
+	defer ns.mtx.Unlock()
+
+	now := time.Now()
+	if ns.current == "" || now.Sub(ns.rotatedAt) >= ns.rotation {
+		ns.previous = ns.current
+		ns.current = newNonce()
+		ns.rotatedAt = now
+	}
+
+	if ns.previous == "" {
+		return []string{ns.current}
+	}
+
+	return []string{ns.current, ns.previous}
+}
+
+func newNonce() string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+
+	return base64.RawURLEncoding.EncodeToString(nonce)
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dahbura.me/api/security/jose"
+)
+
+// signDpopProof returns an ES256 DPoP proof with the public key of key in
+// its header
+func signDpopProof(t *testing.T, key *ecdsa.PrivateKey, claims map[string]interface{}) string {
+	jwk, err := jose.NewJwk(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoder := base64.RawURLEncoding.EncodeToString
+
+	header, _ := json.Marshal(map[string]interface{}{"typ": "dpop+jwt", "alg": "ES256", "jwk": jwk})
+	payload, _ := json.Marshal(claims)
+	input := encoder(header) + "." + encoder(payload)
+
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+	return input + "." + encoder(signature)
+}
+
+func TestCheckJwtDpop(t *testing.T) {
+	tokens := newTestTokens(t)
+
+	proofKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	jwk, _ := jose.NewJwk(proofKey.Public())
+	jkt, _ := jwk.Thumbprint(crypto.SHA256)
+
+	bound := func(jkt string) string {
+		return tokens.sign(t, time.Hour, map[string]interface{}{
+			"permissions": []string{"read:users"},
+			"cnf":         map[string]interface{}{"jkt": jkt},
+		})
+	}
+
+	token := bound(jkt)
+	otherToken := bound("other-thumbprint")
+
+	proof := func(changes map[string]interface{}) string {
+		claims := map[string]interface{}{
+			"jti": newNonce(),
+			"htm": http.MethodGet,
+			"htu": "http://example.com/users",
+			"iat": time.Now().Unix(),
+			"ath": jose.AccessTokenHash(token),
+		}
+		for name, value := range changes {
+			claims[name] = value
+		}
+
+		return signDpopProof(t, proofKey, claims)
+	}
+
+	replayed := proof(nil)
+
+	opts := CheckJwtOpts{
+		Issuers: []TrustedIssuer{tokens.issuer()},
+		Dpop:    &DpopOpts{ProofMaxAge: time.Minute, Leeway: time.Second},
+	}
+
+	checkJwt := CheckJwt(opts)
+
+	testCases := []struct {
+		name          string
+		authorization string
+		proof         string
+		status        int
+		code          string
+	}{
+		{"valid", "DPoP " + token, proof(nil), http.StatusOK, ""},
+		{"first use", "DPoP " + token, replayed, http.StatusOK, ""},
+		{"replayed jti", "DPoP " + token, replayed, http.StatusUnauthorized, "invalid_dpop_proof"},
+		{"wrong htm", "DPoP " + token, proof(map[string]interface{}{"htm": http.MethodPost}), http.StatusUnauthorized, "invalid_dpop_proof"},
+		{"wrong htu", "DPoP " + token, proof(map[string]interface{}{"htu": "http://example.com/clients"}), http.StatusUnauthorized, "invalid_dpop_proof"},
+		{"wrong ath", "DPoP " + token, proof(map[string]interface{}{"ath": jose.AccessTokenHash("other")}), http.StatusUnauthorized, "invalid_dpop_proof"},
+		{"iat too old", "DPoP " + token, proof(map[string]interface{}{"iat": time.Now().Add(-time.Hour).Unix()}), http.StatusUnauthorized, "invalid_dpop_proof"},
+		{"iat in the future", "DPoP " + token, proof(map[string]interface{}{"iat": time.Now().Add(time.Hour).Unix()}), http.StatusUnauthorized, "invalid_dpop_proof"},
+		{"missing proof", "DPoP " + token, "", http.StatusUnauthorized, "invalid_dpop_proof"},
+		{"other proof key", "DPoP " + token, signDpopProof(t, otherKey, map[string]interface{}{
+			"jti": "other", "htm": http.MethodGet, "htu": "http://example.com/users", "iat": time.Now().Unix(), "ath": jose.AccessTokenHash(token),
+		}), http.StatusUnauthorized, "invalid_token"},
+		{"cnf.jkt mismatch", "DPoP " + otherToken, proof(map[string]interface{}{"ath": jose.AccessTokenHash(otherToken)}), http.StatusUnauthorized, "invalid_token"},
+		{"bound token as bearer", "Bearer " + token, "", http.StatusUnauthorized, "invalid_token"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			req.Header.Set("Authorization", tc.authorization)
+			if tc.proof != "" {
+				req.Header.Set("DPoP", tc.proof)
+			}
+
+			w := serveHandlers(req, checkJwt())
+			challenge := strings.Join(w.Header().Values("WWW-Authenticate"), ", ")
+
+			if w.Code != tc.status || !strings.Contains(challenge, tc.code) {
+				t.Fatalf(`CheckJwt() = %d, %q, want match for %d, %s`, w.Code, challenge, tc.status, tc.code)
+			}
+		})
+	}
+}
+
+func TestCheckJwtDpopNotConfigured(t *testing.T) {
+	tokens := newTestTokens(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set("Authorization", "DPoP "+tokens.sign(t, time.Hour, nil))
+	req.Header.Set("DPoP", "proof")
+
+	w := serveTest(CheckJwtOpts{Issuers: []TrustedIssuer{tokens.issuer()}}, req)
+
+	challenge := strings.Join(w.Header().Values("WWW-Authenticate"), ", ")
+	if w.Code != http.StatusUnauthorized || challenge != "Bearer" {
+		t.Fatalf(`CheckJwt() = %d, %q, want match for 401, Bearer`, w.Code, challenge)
+	}
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"dahbura.me/api/security/jose"
+	"dahbura.me/api/security/oauth2/introspection"
+	httppkg "dahbura.me/api/util/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// abortWithTokenError rejects the request as RFC 6750 section 3 describes:
+// no error code when bearer credentials are missing, invalid_request when
+// the Authorization header is malformed and invalid_token otherwise. When
+// keys or introspection are unreachable the token may well be valid, so
+// the request fails with 503 and no challenge
+func abortWithTokenError(c *gin.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch {
+	case errors.Is(err, jose.ErrKeyUnavailable) || errors.Is(err, introspection.ErrUnavailable):
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"msg": err.Error(), "error": "temporarily_unavailable"})
+		c.Error(err)
+	case errors.Is(err, httppkg.ErrAuthorizationMissing):
+		abortWithChallenge(c, http.StatusUnauthorized, "", err, "")
+	case errors.Is(err, httppkg.ErrAuthorizationMalformed):
+		abortWithChallenge(c, http.StatusBadRequest, "invalid_request", err, "")
+	default:
+		abortWithChallenge(c, http.StatusUnauthorized, "invalid_token", err, "")
+	}
+
+	return true
+}
+
+// abortWithInsufficientScope rejects a valid token that lacks the scope
+// the resource requires
+func abortWithInsufficientScope(c *gin.Context, scope string, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	abortWithChallenge(c, http.StatusForbidden, "insufficient_scope", err, scope)
+
+	return true
+}
+
+func abortWithChallenge(c *gin.Context, status int, code string, err error, scope string) {
+	params := []string{}
+	if code != "" {
+		params = append(params, fmt.Sprintf(`error="%s"`, code))
+		params = append(params, fmt.Sprintf(`error_description="%s"`, challengeValue(err.Error())))
+	}
+	if scope != "" {
+		params = append(params, fmt.Sprintf(`scope="%s"`, challengeValue(scope)))
+	}
+
+	challenge := "Bearer"
+	if len(params) > 0 {
+		challenge += " " + strings.Join(params, ", ")
+	}
+
+	// added next to a DPoP challenge when one was already set
+	c.Writer.Header().Add("WWW-Authenticate", challenge)
+
+	body := gin.H{"msg": err.Error()}
+	if code != "" {
+		body["error"] = code
+	}
+
+	c.AbortWithStatusJSON(status, body)
+	c.Error(err)
+}
+
+// challengeValue drops the characters RFC 6750 does not allow in
+// error_description and scope values
+func challengeValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return -1
+		}
+
+		return r
+	}, value)
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+
+	"dahbura.me/api/security/jose"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MtlsOpts enables certificate-bound access tokens (RFC 8705)
+type MtlsOpts struct {
+	// ProxyHeader carries the client certificate (URL encoded PEM or
+	// base64 DER) when TLS is terminated by a proxy
+	ProxyHeader string
+	// TrustedProxies are the networks ProxyHeader is accepted from
+	TrustedProxies []*net.IPNet
+}
+
+// checkCertificateBinding compares the "cnf.x5t#S256" of the token with
+// the thumbprint of the client certificate of the request
+func checkCertificateBinding(c *gin.Context, opts *MtlsOpts, claims *jose.Claims, required bool) error {
+	x5t, bound := claims.Confirmation("x5t#S256")
+	if !bound {
+		if required {
+			return errors.New("certificate bound token required")
+		}
+
+		return nil
+	}
+
+	if opts == nil {
+		return errors.New("certificate bound tokens not supported")
+	}
+
+	der, err := clientCertificate(c, opts)
+	if err != nil {
+		return err
+	}
+
+	thumbprint := jose.CertificateThumbprint(der)
+	if subtle.ConstantTimeCompare([]byte(thumbprint), []byte(x5t)) != 1 {
+		return errors.New("client certificate does not match token binding")
+	}
+
+	return nil
+}
+
+// clientCertificate returns the DER encoded client certificate presented
+// on the TLS connection or forwarded by a trusted proxy
+func clientCertificate(c *gin.Context, opts *MtlsOpts) ([]byte, error) {
+	if c.Request.TLS != nil && len(c.Request.TLS.PeerCertificates) > 0 {
+		return c.Request.TLS.PeerCertificates[0].Raw, nil
+	}
+
+	if opts.ProxyHeader == "" || !isTrustedProxy(c.Request.RemoteAddr, opts.TrustedProxies) {
+		return nil, errors.New("client certificate not found")
+	}
+
+	header := c.GetHeader(opts.ProxyHeader)
+	if header == "" {
+		return nil, errors.New("client certificate not found")
+	}
+
+	return parseForwardedCertificate(header)
+}
+
+func parseForwardedCertificate(header string) ([]byte, error) {
+	unescaped, err := url.PathUnescape(header)
+	if err != nil {
+		return nil, errors.New("unable to decode client certificate")
+	}
+
+	block, _ := pem.Decode([]byte(unescaped))
+	if block != nil {
+		if block.Type != "CERTIFICATE" {
+			return nil, errors.New("unable to decode client certificate")
+		}
+
+		return block.Bytes, nil
+	}
+
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(header))
+	if err != nil {
+		return nil, errors.New("unable to decode client certificate")
+	}
+
+	return der, nil
+}
+
+// isTrustedProxy checks the peer address of the connection, forwarded
+// for headers are not considered
+func isTrustedProxy(remoteAddr string, trustedProxies []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"dahbura.me/api/security/jose"
+)
+
+func newTestCertificate(t *testing.T) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func TestCheckJwtMtls(t *testing.T) {
+	tokens := newTestTokens(t)
+	cert := newTestCertificate(t)
+	otherCert := newTestCertificate(t)
+
+	token := tokens.sign(t, time.Hour, map[string]interface{}{
+		"permissions": []string{"read:users"},
+		"cnf":         map[string]interface{}{"x5t#S256": jose.CertificateThumbprint(cert.Raw)},
+	})
+	unbound := tokens.sign(t, time.Hour, map[string]interface{}{"permissions": []string{"read:users"}})
+
+	_, proxy, _ := net.ParseCIDR("192.0.2.0/24")
+	forwarded := url.PathEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+
+	opts := CheckJwtOpts{
+		Issuers: []TrustedIssuer{tokens.issuer()},
+		Mtls:    &MtlsOpts{ProxyHeader: "X-Client-Cert", TrustedProxies: []*net.IPNet{proxy}},
+	}
+
+	testCases := []struct {
+		name       string
+		token      string
+		peer       *x509.Certificate
+		remoteAddr string
+		header     string
+		status     int
+	}{
+		{"matching certificate", token, cert, "", "", http.StatusOK},
+		{"missing certificate", token, nil, "", "", http.StatusUnauthorized},
+		{"mismatched certificate", token, otherCert, "", "", http.StatusUnauthorized},
+		{"unbound token without certificate", unbound, nil, "", "", http.StatusOK},
+		{"forwarded by trusted proxy", token, nil, "192.0.2.10:443", forwarded, http.StatusOK},
+		{"forwarded by untrusted proxy", token, nil, "198.51.100.10:443", forwarded, http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+
+			if tc.peer != nil {
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tc.peer}}
+			}
+			if tc.remoteAddr != "" {
+				req.RemoteAddr = tc.remoteAddr
+			}
+			if tc.header != "" {
+				req.Header.Set("X-Client-Cert", tc.header)
+			}
+
+			w := serveTest(opts, req)
+			challenge := w.Header().Get("WWW-Authenticate")
+
+			if w.Code != tc.status || (tc.status != http.StatusOK && !strings.Contains(challenge, `error="invalid_token"`)) {
+				t.Fatalf(`CheckJwt() = %d, %q, want match for %d`, w.Code, challenge, tc.status)
+			}
+		})
+	}
+}
+
+func TestCheckJwtMtlsRequired(t *testing.T) {
+	tokens := newTestTokens(t)
+	cert := newTestCertificate(t)
+
+	issuer := tokens.issuer()
+	issuer.RequireMtls = true
+
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.sign(t, time.Hour, map[string]interface{}{"permissions": []string{"read:users"}}))
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+
+	w := serveTest(CheckJwtOpts{Issuers: []TrustedIssuer{issuer}, Mtls: &MtlsOpts{}}, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf(`CheckJwt() = %d, want match for 401 for an unbound token`, w.Code)
+	}
+}
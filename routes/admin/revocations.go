@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"net/http"
+
+	"dahbura.me/api/config"
+	"dahbura.me/api/database/models"
+	"dahbura.me/api/security/revocation"
+	httppkg "dahbura.me/api/util/http"
+	"dahbura.me/api/util/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CreateRevocation(c *gin.Context) {
+	rev := models.Revocation{}
+	err := c.ShouldBindJSON(&rev)
+	if httppkg.HandleError(c, err) {
+		return
+	}
+
+	validate := validation.GetValidator()
+
+	err = validate.Struct(rev)
+	if httppkg.HandleError(c, err) {
+		return
+	}
+
+	err = revocation.GetStore().Add(&rev)
+	if httppkg.HandleError(c, err) {
+		return
+	}
+
+	c.Header("Content-Type", config.MimeApplicationJson)
+	c.JSON(http.StatusCreated, rev)
+}
+
+func GetRevocations(c *gin.Context) {
+	revs, err := revocation.GetStore().List()
+	if httppkg.HandleError(c, err) {
+		return
+	}
+
+	c.Header("Content-Type", config.MimeApplicationJson)
+	c.JSON(http.StatusOK, revs)
+}
@@ -1,20 +1,41 @@
 package routes
 
 import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 
 	"dahbura.me/api/config"
 	"dahbura.me/api/middleware"
+	"dahbura.me/api/routes/admin"
 	"dahbura.me/api/routes/database"
 	"dahbura.me/api/routes/management"
+	"dahbura.me/api/routes/wellknown"
+	"dahbura.me/api/security/jose"
+	"dahbura.me/api/security/oauth2/introspection"
+	"dahbura.me/api/security/revocation"
 
 	"github.com/gin-gonic/gin"
 )
 
 func Register(router *gin.Engine) {
+	issuers := trustedIssuers()
+	if err := validateIssuers(issuers); err != nil {
+		log.Fatalf("Error configuring issuers: %s\n", err)
+	}
+
 	checkJwtOpts := middleware.CheckJwtOpts{
-		TokenAudience: config.TokenAudience,
-		TokenIssuer:   config.TokenIssuer + "/",
+		Issuers:        issuers,
+		DecryptionKeys: decryptionKeys(),
+		Introspector:   introspector(),
+		Dpop:           dpopOpts(),
+		Mtls:           mtlsOpts(),
+		Revocations:    revocation.GetStore(),
 	}
 	checkJwt := middleware.CheckJwt(checkJwtOpts)
 
@@ -26,6 +47,13 @@ func Register(router *gin.Engine) {
 	rg := router.Group("/")
 	{
 		rg.Handle(http.MethodGet, "/", rootHandler)
+		rg.Handle(http.MethodGet, "/.well-known/jwks.json", wellknown.GetJwks)
+	}
+
+	rgAdmin := rg.Group("/admin", checkJwt())
+	{
+		rgAdmin.Handle(http.MethodGet, "revocations", checkScope("read:revocations"), admin.GetRevocations)
+		rgAdmin.Handle(http.MethodPost, "revocations", checkScope("create:revocations"), admin.CreateRevocation)
 	}
 
 	rgDb := rg.Group("/db", checkJwt())
@@ -50,6 +78,324 @@ func Register(router *gin.Engine) {
 	}
 }
 
+func trustedIssuers() []middleware.TrustedIssuer {
+	issuers := []middleware.TrustedIssuer{}
+	trustAnchors := readCertPool(config.TokenTrustAnchorFile)
+	certificateRoots := readCertPool(config.TokenCertificateRootFile)
+
+	if config.TokenIssuer != "" {
+		issuers = append(issuers, middleware.TrustedIssuer{
+			TokenAudience:  config.TokenAudience,
+			TokenIssuer:    config.TokenIssuer + "/",
+			JwksUrl:        config.TokenJwksUrl,
+			Leeway:         config.TokenLeeway,
+			MaxTokenAge:    config.TokenMaxAge,
+			RequiredClaims: config.TokenRequiredClaims,
+			Algorithms:     config.TokenAlgorithms,
+			ScopesClaim:    "permissions",
+			StrictProfile:  config.TokenProfileStrict,
+			HeaderKeys:     headerKeyPolicy(config.TokenHeaderKeyUrls, trustAnchors),
+			Certificates:   certificatePolicy(config.TokenValidateCertificates, certificateRoots),
+			RequireDpop:    config.TokenRequireDpop,
+			RequireMtls:    config.TokenRequireMtls,
+			Keys:           keySource(config.TokenJwksFile, []byte(config.TokenJwksInline), config.TokenPinnedKeysFile),
+			Offline:        config.TokenJwksOffline,
+			Secrets:        hmacSecrets(config.TokenAudience, config.TokenAlgorithms, hmacSecretConfigs(config.TokenHmacSecrets)),
+		})
+	}
+
+	for _, issuer := range config.TokenIssuers {
+		issuers = append(issuers, middleware.TrustedIssuer{
+			TokenAudience:  issuer.Audience,
+			TokenIssuer:    issuer.Issuer,
+			JwksUrl:        issuer.JwksUrl,
+			Leeway:         config.TokenLeeway,
+			MaxTokenAge:    config.TokenMaxAge,
+			RequiredClaims: issuer.RequiredClaims,
+			Algorithms:     issuer.Algorithms,
+			ScopesClaim:    issuer.ScopesClaim,
+			StrictProfile:  issuer.StrictProfile,
+			HeaderKeys:     headerKeyPolicy(issuer.HeaderKeyUrls, trustAnchors),
+			Certificates:   certificatePolicy(issuer.ValidateCertificates, certificateRoots),
+			RequireDpop:    issuer.RequireDpop,
+			RequireMtls:    issuer.RequireMtls,
+			Keys:           keySource(issuer.JwksFile, issuer.Jwks, issuer.PinnedKeysFile),
+			Offline:        issuer.Offline,
+			Secrets:        hmacSecrets(issuer.Audience, issuer.Algorithms, issuer.HmacSecrets),
+		})
+	}
+
+	return issuers
+}
+
+// validateIssuers rejects an issuer trusted twice for the same audience,
+// tokens are matched to an issuer by iss and aud so the second entry would
+// never be selected
+func validateIssuers(issuers []middleware.TrustedIssuer) error {
+	seen := map[string]bool{}
+	for _, issuer := range issuers {
+		key := issuer.TokenIssuer + "|" + issuer.TokenAudience
+		if seen[key] {
+			return fmt.Errorf("duplicate issuer %s for audience %s", issuer.TokenIssuer, issuer.TokenAudience)
+		}
+		seen[key] = true
+	}
+
+	return nil
+}
+
+// keySource combines the local key sources configured for an issuer,
+// inline keys first, then pinned keys and the JWKS file
+func keySource(jwksFile string, inlineJwks []byte, pinnedKeysFile string) jose.KeySource {
+	sources := []jose.KeySource{}
+
+	if len(inlineJwks) > 0 {
+		jwks, err := jose.ParseJwkSet(inlineJwks)
+		if err != nil {
+			log.Fatalf("Error parsing inline JWKS: %s\n", err)
+		}
+
+		sources = append(sources, jose.NewStaticKeySource(jwks))
+	}
+
+	if pinnedKeysFile != "" {
+		pemData, err := os.ReadFile(pinnedKeysFile)
+		if err != nil {
+			log.Fatalf("Error reading pinned keys: %s\n", err)
+		}
+
+		pinned, err := jose.NewPinnedKeySource(pemData)
+		if err != nil {
+			log.Fatalf("Error parsing pinned keys: %s\n", err)
+		}
+
+		sources = append(sources, pinned)
+	}
+
+	if jwksFile != "" {
+		file, err := jose.NewFileKeySource(jwksFile)
+		if err != nil {
+			log.Fatalf("Error reading JWKS file: %s\n", err)
+		}
+
+		sources = append(sources, file)
+	}
+
+	if len(sources) == 0 {
+		return nil
+	}
+
+	return jose.KeySources(sources...)
+}
+
+func hmacSecretConfigs(secrets []string) []config.HmacSecretConfig {
+	configs := []config.HmacSecretConfig{}
+	for _, secret := range secrets {
+		configs = append(configs, config.HmacSecretConfig{Secret: secret})
+	}
+
+	return configs
+}
+
+// hmacSecrets binds each secret to the audience of its issuer, tokens are
+// only verified for that audience so a secret configured for another one
+// could never be used and is refused at startup. Secrets are base64url
+// encoded and must be at least as long as the hash of every algorithm
+// allowed for the issuer (HS256 when none is configured), RFC 7518
+// section 3.2
+func hmacSecrets(audience string, algorithms []string, configs []config.HmacSecretConfig) []jose.HmacSecret {
+	if len(configs) == 0 {
+		return nil
+	}
+
+	if audience == "" {
+		log.Fatalf("Error reading HMAC secret: issuer has no audience\n")
+	}
+
+	if len(algorithms) == 0 {
+		algorithms = []string{"HS256"}
+	}
+
+	keySize := 0
+	for _, alg := range algorithms {
+		size, err := jose.HmacKeySize(alg)
+		if err != nil {
+			log.Fatalf("Error reading HMAC secret: %s\n", err)
+		}
+
+		if size > keySize {
+			keySize = size
+		}
+	}
+
+	secrets := []jose.HmacSecret{}
+	for _, c := range configs {
+		secret, err := base64.RawURLEncoding.DecodeString(c.Secret)
+		if err != nil {
+			log.Fatalf("Error decoding HMAC secret: %s\n", err)
+		}
+
+		if len(secret) < keySize {
+			log.Fatalf("Error reading HMAC secret: shorter than %d bytes\n", keySize)
+		}
+
+		if c.Audience != "" && c.Audience != audience {
+			log.Fatalf("Error reading HMAC secret: audience %s does not match issuer audience %s\n", c.Audience, audience)
+		}
+
+		secrets = append(secrets, jose.HmacSecret{
+			Audience: audience,
+			Secret:   secret,
+		})
+	}
+
+	return secrets
+}
+
+func headerKeyPolicy(allowedUrls []string, trustAnchors *x509.CertPool) *jose.HeaderKeyPolicy {
+	if len(allowedUrls) == 0 && trustAnchors == nil {
+		return nil
+	}
+
+	policy := jose.HeaderKeyPolicy{
+		AllowedUrls:  allowedUrls,
+		TrustAnchors: trustAnchors,
+	}
+
+	return &policy
+}
+
+// certificatePolicy requires x5c certificates on signing keys, validated
+// against roots when configured and otherwise only for their validity
+func certificatePolicy(validate bool, roots *x509.CertPool) *jose.CertificatePolicy {
+	if !validate {
+		return nil
+	}
+
+	policy := jose.CertificatePolicy{
+		Roots:      roots,
+		RequireX5c: true,
+	}
+
+	return &policy
+}
+
+func readCertPool(file string) *x509.CertPool {
+	if file == "" {
+		return nil
+	}
+
+	pemData, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatalf("Error reading certificates %s: %s\n", file, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemData) {
+		log.Fatalf("Error parsing certificates %s: no certificates found\n", file)
+	}
+
+	return pool
+}
+
+func dpopOpts() *middleware.DpopOpts {
+	if !config.DpopEnabled {
+		return nil
+	}
+
+	opts := middleware.DpopOpts{
+		PublicUrl:     config.DpopPublicUrl,
+		ProofMaxAge:   config.DpopProofMaxAge,
+		Leeway:        config.TokenLeeway,
+		NonceRotation: config.DpopNonceRotation,
+	}
+
+	return &opts
+}
+
+// mtlsOpts accepts certificate-bound tokens when the server verifies
+// client certificates itself or a trusted proxy forwards them
+func mtlsOpts() *middleware.MtlsOpts {
+	if config.TlsClientAuth == "" && config.MtlsProxyHeader == "" {
+		return nil
+	}
+
+	trustedProxies := []*net.IPNet{}
+	for _, proxy := range config.MtlsTrustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			log.Fatalf("Error parsing trusted proxy %s: %s\n", proxy, err)
+		}
+
+		trustedProxies = append(trustedProxies, network)
+	}
+
+	opts := middleware.MtlsOpts{
+		ProxyHeader:    config.MtlsProxyHeader,
+		TrustedProxies: trustedProxies,
+	}
+
+	return &opts
+}
+
+func introspector() *introspection.Introspector {
+	if config.TokenIntrospectionUrl == "" {
+		return nil
+	}
+
+	opts := introspection.IntrospectorOpts{
+		Url:          config.TokenIntrospectionUrl,
+		ClientId:     config.TokenIntrospectionClientId,
+		ClientSecret: config.TokenIntrospectionClientSecret,
+		Audience:     config.TokenIntrospectionAudience,
+		Leeway:       config.TokenLeeway,
+	}
+
+	introspector, err := introspection.NewIntrospector(opts)
+	if err != nil {
+		log.Fatalf("Error configuring token introspection: %s\n", err)
+	}
+
+	return introspector
+}
+
+func decryptionKeys() []jose.DecryptionKey {
+	keys := []jose.DecryptionKey{}
+
+	if config.TokenDecryptionKeyFile != "" {
+		pemData, err := os.ReadFile(config.TokenDecryptionKeyFile)
+		if err != nil {
+			log.Fatalf("Error reading token decryption keys: %s\n", err)
+		}
+
+		privateKeys, err := jose.ParseDecryptionKeys(pemData)
+		if err != nil {
+			log.Fatalf("Error parsing token decryption keys: %s\n", err)
+		}
+
+		keys = append(keys, privateKeys...)
+	}
+
+	if config.TokenDecryptionSecret != "" {
+		secret, err := base64.RawURLEncoding.DecodeString(config.TokenDecryptionSecret)
+		if err != nil {
+			log.Fatalf("Error decoding token decryption secret: %s\n", err)
+		}
+
+		keys = append(keys, jose.DecryptionKey{Key: secret})
+	}
+
+	return keys
+}
+
 func rootHandler(c *gin.Context) {
 	c.String(http.StatusOK, "OK")
 }
@@ -0,0 +1,31 @@
+package wellknown
+
+import (
+	"fmt"
+	"net/http"
+
+	"dahbura.me/api/config"
+	"dahbura.me/api/security/signing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetJwks(c *gin.Context) {
+	// the key set is ours, failing to build it is a server fault
+	keyManager, err := signing.GetKeyManager()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
+		return
+	}
+
+	jwks, err := keyManager.PublicJwkSet()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
+		return
+	}
+
+	maxAge := int(config.DefaultJwksPublishMaxAge.Seconds())
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+	c.Header("Content-Type", config.MimeApplicationJson)
+	c.JSON(http.StatusOK, jwks)
+}
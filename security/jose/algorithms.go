@@ -0,0 +1,102 @@
+package jose
+
+import (
+	"strings"
+)
+
+// DefaultAlgorithms are the asymmetric signing algorithms accepted
+// when an issuer does not configure its own allowlist
+var DefaultAlgorithms = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// accessTokenClaims are required by RFC 9068 section 2.2
+var accessTokenClaims = []string{"iss", "exp", "aud", "sub", "client_id", "iat", "jti"}
+
+// verifyHeader rejects tokens whose alg is not allowed for the issuer
+// and, in the access token profile, tokens not typed "at+jwt"
+func verifyHeader(header *JoseHeader, opts VerifyOpts) error {
+	verify := verifyAlgorithm
+	if len(opts.Secrets) > 0 {
+		verify = verifyHmacAlgorithm
+	}
+
+	err := verify(header.Alg, opts.Algorithms)
+	if err != nil {
+		return err
+	}
+
+	if opts.AccessTokenProfile && !isAccessTokenType(header.Typ) {
+		return verificationError(ErrInvalidTokenType, "not at+jwt")
+	}
+
+	return nil
+}
+
+func verifyAlgorithm(alg string, allowed []string) error {
+	if alg == "" || strings.EqualFold(alg, "none") || strings.HasPrefix(alg, "HS") {
+		return verificationError(ErrAlgorithmNotAllowed, "%s", alg)
+	}
+
+	if len(allowed) == 0 {
+		allowed = DefaultAlgorithms
+	}
+
+	for _, a := range allowed {
+		if a == alg {
+			return nil
+		}
+	}
+
+	return verificationError(ErrAlgorithmNotAllowed, "%s", alg)
+}
+
+// verifyKeyAlgorithm ensures a key registered for one algorithm (e.g.
+// RS256) cannot be used to verify a token signed with another (e.g. PS256)
+// and that the key is a signing key of the type the algorithm requires
+func verifyKeyAlgorithm(jwk *Jwk, alg string) error {
+	if jwk.Alg != "" && jwk.Alg != alg {
+		return verificationError(ErrKeyNotAllowed, "key algorithm does not match token algorithm")
+	}
+
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return verificationError(ErrKeyNotAllowed, "key not intended for signatures")
+	}
+
+	if jwk.Kty != keyTypeForAlgorithm(alg) {
+		return verificationError(ErrKeyNotAllowed, "key type does not match token algorithm")
+	}
+
+	return nil
+}
+
+func keyTypeForAlgorithm(alg string) string {
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		return "RSA"
+	case "ES256", "ES384", "ES512":
+		return "EC"
+	case "EdDSA":
+		return "OKP"
+	default:
+		return ""
+	}
+}
+
+func isAccessTokenType(typ string) bool {
+	typ = strings.ToLower(typ)
+	return typ == "at+jwt" || typ == "application/at+jwt"
+}
+
+func validateAccessTokenClaims(claims *Claims) error {
+	for _, name := range accessTokenClaims {
+		if !claims.Has(name) {
+			return claimError(ErrMissingClaim, name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package jose
+
+import "testing"
+
+func TestVerifyAlgorithm(t *testing.T) {
+	testCases := []struct {
+		name    string
+		alg     string
+		allowed []string
+		valid   bool
+	}{
+		{"default rsa", "RS256", nil, true},
+		{"default eddsa", "EdDSA", nil, true},
+		{"none", "none", nil, false},
+		{"none uppercase", "NONE", []string{"NONE"}, false},
+		{"empty", "", nil, false},
+		{"hmac", "HS256", nil, false},
+		{"hmac allowlisted", "HS256", []string{"HS256"}, false},
+		{"allowlisted", "ES256", []string{"ES256"}, true},
+		{"not allowlisted", "RS256", []string{"ES256"}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifyAlgorithm(tc.alg, tc.allowed)
+			if tc.valid != (err == nil) {
+				t.Fatalf(`verifyAlgorithm(%q, %q) = %v, want valid %t`, tc.alg, tc.allowed, err, tc.valid)
+			}
+		})
+	}
+}
+
+func TestVerifyKeyAlgorithm(t *testing.T) {
+	testCases := []struct {
+		name  string
+		jwk   Jwk
+		alg   string
+		valid bool
+	}{
+		{"unset", Jwk{Kty: "RSA"}, "PS256", true},
+		{"match", Jwk{Kty: "RSA", Alg: "PS256", Use: "sig"}, "PS256", true},
+		{"pkcs1 key pss token", Jwk{Kty: "RSA", Alg: "RS256"}, "PS256", false},
+		{"pss key pkcs1 token", Jwk{Kty: "RSA", Alg: "PS256"}, "RS256", false},
+		{"encryption key", Jwk{Kty: "RSA", Use: "enc"}, "RS256", false},
+		{"rsa key ec token", Jwk{Kty: "RSA"}, "ES256", false},
+		{"ec key eddsa token", Jwk{Kty: "EC"}, "EdDSA", false},
+		{"okp key eddsa token", Jwk{Kty: "OKP"}, "EdDSA", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifyKeyAlgorithm(&tc.jwk, tc.alg)
+			if tc.valid != (err == nil) {
+				t.Fatalf(`verifyKeyAlgorithm(%+v, %q) = %v, want valid %t`, tc.jwk, tc.alg, err, tc.valid)
+			}
+		})
+	}
+}
+
+func TestVerifyHeaderAccessTokenProfile(t *testing.T) {
+	testCases := []struct {
+		typ   string
+		valid bool
+	}{
+		{"at+jwt", true},
+		{"application/at+jwt", true},
+		{"AT+JWT", true},
+		{"JWT", false},
+		{"", false},
+	}
+
+	opts := VerifyOpts{AccessTokenProfile: true}
+	for _, tc := range testCases {
+		t.Run(tc.typ, func(t *testing.T) {
+			header := &JoseHeader{Alg: "RS256", Typ: tc.typ}
+			err := verifyHeader(header, opts)
+			if tc.valid != (err == nil) {
+				t.Fatalf(`verifyHeader(typ %q) = %v, want valid %t`, tc.typ, err, tc.valid)
+			}
+		})
+	}
+}
+
+func TestValidateAccessTokenClaims(t *testing.T) {
+	claims := &Claims{
+		Iss:    "https://issuer/",
+		Sub:    "subject",
+		Aud:    []string{"audience"},
+		Exp:    2,
+		Iat:    1,
+		Jti:    "id",
+		Custom: map[string]interface{}{"client_id": "client"},
+	}
+
+	err := validateAccessTokenClaims(claims)
+	if err != nil {
+		t.Fatalf(`validateAccessTokenClaims() = %v, want match for nil`, err)
+	}
+
+	delete(claims.Custom, "client_id")
+	err = validateAccessTokenClaims(claims)
+	if err == nil {
+		t.Fatalf(`validateAccessTokenClaims() = nil, want error for missing client_id`)
+	}
+}
@@ -0,0 +1,243 @@
+package jose
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Claims holds the registered claims of a verified JWT along with
+// every other (public or private) claim found in the payload
+type Claims struct {
+	Iss    string
+	Sub    string
+	Aud    []string
+	Exp    int64
+	Nbf    int64
+	Iat    int64
+	Jti    string
+	Custom map[string]interface{}
+}
+
+type registeredClaims struct {
+	Iss string      `json:"iss"`
+	Sub string      `json:"sub"`
+	Aud interface{} `json:"aud"`
+	Exp json.Number `json:"exp"`
+	Nbf json.Number `json:"nbf"`
+	Iat json.Number `json:"iat"`
+	Jti string      `json:"jti"`
+}
+
+var registeredClaimNames = []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti"}
+
+func (claims *Claims) UnmarshalJSON(data []byte) error {
+	var registered registeredClaims
+	if err := json.Unmarshal(data, &registered); err != nil {
+		return err
+	}
+
+	custom := map[string]interface{}{}
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return err
+	}
+
+	for _, name := range registeredClaimNames {
+		delete(custom, name)
+	}
+
+	var aud []string
+	if registered.Aud != nil {
+		var err error
+		aud, err = parseAudience(registered.Aud)
+		if err != nil {
+			return err
+		}
+	}
+
+	exp, err := parseNumericDate(registered.Exp)
+	if err != nil {
+		return err
+	}
+
+	nbf, err := parseNumericDate(registered.Nbf)
+	if err != nil {
+		return err
+	}
+
+	iat, err := parseNumericDate(registered.Iat)
+	if err != nil {
+		return err
+	}
+
+	*claims = Claims{
+		Iss:    registered.Iss,
+		Sub:    registered.Sub,
+		Aud:    aud,
+		Exp:    exp,
+		Nbf:    nbf,
+		Iat:    iat,
+		Jti:    registered.Jti,
+		Custom: custom,
+	}
+
+	return nil
+}
+
+func (claims Claims) MarshalJSON() ([]byte, error) {
+	payload := map[string]interface{}{}
+	for name, value := range claims.Custom {
+		payload[name] = value
+	}
+
+	if claims.Iss != "" {
+		payload["iss"] = claims.Iss
+	}
+	if claims.Sub != "" {
+		payload["sub"] = claims.Sub
+	}
+	if len(claims.Aud) == 1 {
+		payload["aud"] = claims.Aud[0]
+	} else if len(claims.Aud) > 1 {
+		payload["aud"] = claims.Aud
+	}
+	if claims.Exp != 0 {
+		payload["exp"] = claims.Exp
+	}
+	if claims.Nbf != 0 {
+		payload["nbf"] = claims.Nbf
+	}
+	if claims.Iat != 0 {
+		payload["iat"] = claims.Iat
+	}
+	if claims.Jti != "" {
+		payload["jti"] = claims.Jti
+	}
+
+	return json.Marshal(payload)
+}
+
+// ExpirationTime returns the local expiration time on or after
+// which the JWT MUST NOT be accepted
+func (claims *Claims) ExpirationTime() time.Time {
+	return time.Unix(claims.Exp, 0)
+}
+
+// IssuedAt returns the local time at which the JWT was issued
+func (claims *Claims) IssuedAt() time.Time {
+	return time.Unix(claims.Iat, 0)
+}
+
+// NotBefore returns the local time before which the JWT MUST NOT
+// be accepted
+func (claims *Claims) NotBefore() time.Time {
+	return time.Unix(claims.Nbf, 0)
+}
+
+// Has reports whether the named claim, registered or custom, is present
+func (claims *Claims) Has(name string) bool {
+	switch name {
+	case "iss":
+		return claims.Iss != ""
+	case "sub":
+		return claims.Sub != ""
+	case "aud":
+		return len(claims.Aud) > 0
+	case "exp":
+		return claims.Exp != 0
+	case "nbf":
+		return claims.Nbf != 0
+	case "iat":
+		return claims.Iat != 0
+	case "jti":
+		return claims.Jti != ""
+	}
+
+	_, ok := claims.Custom[name]
+	return ok
+}
+
+// Get returns the named custom claim
+func (claims *Claims) Get(name string) (interface{}, bool) {
+	value, ok := claims.Custom[name]
+	return value, ok
+}
+
+// String returns the named custom claim when it is a string
+func (claims *Claims) String(name string) (string, bool) {
+	value, ok := claims.Custom[name]
+	if !ok {
+		return "", false
+	}
+
+	str, ok := value.(string)
+	return str, ok
+}
+
+// Strings returns the named custom claim as a list, accepting either
+// a JSON array of strings or a space-delimited string (e.g. "scope")
+func (claims *Claims) Strings(name string) ([]string, bool) {
+	value, ok := claims.Custom[name]
+	if !ok {
+		return nil, false
+	}
+
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v), true
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			values = append(values, str)
+		}
+		return values, true
+	default:
+		return nil, false
+	}
+}
+
+// Time returns the named custom claim when it is a NumericDate (e.g.
+// "auth_time")
+func (claims *Claims) Time(name string) (time.Time, bool) {
+	value, ok := claims.Custom[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(value), 0), true
+}
+
+// Confirmation returns a member of the "cnf" confirmation claim (RFC
+// 7800), such as "jkt" or "x5t#S256", binding the token to a key
+func (claims *Claims) Confirmation(member string) (string, bool) {
+	value, ok := claims.Custom["cnf"]
+	if !ok {
+		return "", false
+	}
+
+	cnf, ok := value.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+
+	str, ok := cnf[member].(string)
+	return str, ok && str != ""
+}
+
+func parseNumericDate(number json.Number) (int64, error) {
+	if number == "" {
+		return 0, nil
+	}
+
+	value, err := number.Float64()
+	if err != nil {
+		return 0, errors.New("unable to parse numeric date")
+	}
+
+	return int64(value), nil
+}
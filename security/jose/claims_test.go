@@ -0,0 +1,128 @@
+package jose
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const ClaimsJson = `{
+	"iss": "https://issuer/",
+	"sub": "auth0|123",
+	"aud": ["audience1", "audience2"],
+	"exp": 1700000000,
+	"nbf": 1600000000,
+	"iat": 1600000000.5,
+	"jti": "id",
+	"azp": "client",
+	"scope": "read:users create:users",
+	"permissions": ["read:users", "delete:users"],
+	"https://example.com/org_id": "org_123"
+}`
+
+func TestClaimsUnmarshal(t *testing.T) {
+	var claims Claims
+	err := json.Unmarshal([]byte(ClaimsJson), &claims)
+	if err != nil {
+		t.Fatalf(`json.Unmarshal() = %v, want match for nil`, err)
+	}
+
+	if claims.Iss != "https://issuer/" || claims.Sub != "auth0|123" || claims.Jti != "id" {
+		t.Fatalf(`json.Unmarshal() = %+v, want registered string claims`, claims)
+	}
+
+	if claims.Exp != 1700000000 || claims.Nbf != 1600000000 || claims.Iat != 1600000000 {
+		t.Fatalf(`json.Unmarshal() = %+v, want registered numeric claims`, claims)
+	}
+
+	if len(claims.Aud) != 2 || claims.Aud[1] != "audience2" {
+		t.Fatalf(`json.Unmarshal() aud = %q, want match for [audience1 audience2]`, claims.Aud)
+	}
+
+	if _, ok := claims.Get("iss"); ok {
+		t.Fatalf(`claims.Get("iss") = _, true, want registered claims excluded from custom`)
+	}
+
+	azp, ok := claims.String("azp")
+	if !ok || azp != "client" {
+		t.Fatalf(`claims.String("azp") = %q, %t, want match for "client", true`, azp, ok)
+	}
+
+	org, ok := claims.String("https://example.com/org_id")
+	if !ok || org != "org_123" {
+		t.Fatalf(`claims.String("org_id") = %q, %t, want match for "org_123", true`, org, ok)
+	}
+}
+
+func TestClaimsStrings(t *testing.T) {
+	var claims Claims
+	err := json.Unmarshal([]byte(ClaimsJson), &claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name  string
+		claim string
+		want  []string
+		ok    bool
+	}{
+		{"space delimited", "scope", []string{"read:users", "create:users"}, true},
+		{"array", "permissions", []string{"read:users", "delete:users"}, true},
+		{"missing", "roles", nil, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			values, ok := claims.Strings(tc.claim)
+			if ok != tc.ok || len(values) != len(tc.want) {
+				t.Fatalf(`claims.Strings(%q) = %q, %t, want match for %q, %t`, tc.claim, values, ok, tc.want, tc.ok)
+			}
+			for i := range values {
+				if values[i] != tc.want[i] {
+					t.Fatalf(`claims.Strings(%q) = %q, want match for %q`, tc.claim, values, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestClaimsUnmarshalInvalidAudience(t *testing.T) {
+	var claims Claims
+	err := json.Unmarshal([]byte(`{"aud": 5}`), &claims)
+	if err == nil {
+		t.Fatalf(`json.Unmarshal() = nil, want error for numeric audience`)
+	}
+}
+
+func TestClaimsConfirmation(t *testing.T) {
+	var claims Claims
+	err := json.Unmarshal([]byte(`{"cnf": {"jkt": "thumbprint"}}`), &claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jkt, ok := claims.Confirmation("jkt")
+	if !ok || jkt != "thumbprint" {
+		t.Fatalf(`Confirmation("jkt") = %v, %v, want match for thumbprint, true`, jkt, ok)
+	}
+
+	_, ok = claims.Confirmation("x5t#S256")
+	if ok {
+		t.Fatalf(`Confirmation("x5t#S256") = _, true, want false`)
+	}
+}
+
+func TestClaimsTime(t *testing.T) {
+	var claims Claims
+	json.Unmarshal([]byte(`{"auth_time": 1700000000, "acr": "0"}`), &claims)
+
+	authTime, ok := claims.Time("auth_time")
+	if !ok || authTime.Unix() != 1700000000 {
+		t.Fatalf(`Time("auth_time") = %v, %t, want match for 1700000000, true`, authTime, ok)
+	}
+
+	_, ok = claims.Time("acr")
+	if ok {
+		t.Fatalf(`Time("acr") = _, true, want false`)
+	}
+}
@@ -0,0 +1,86 @@
+package jose
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SignDetached returns a compact JWS over the payload with the payload
+// segment left empty ("header..signature"). The payload is signed as is,
+// without base64url encoding, using the unencoded payload option of
+// RFC 7797 ("b64": false, which must be listed in "crit")
+func SignDetached(payload []byte, key *SigningKey) (string, error) {
+	b64 := false
+	joseHeader := JoseHeader{
+		Alg:  key.Alg,
+		Kid:  key.Kid,
+		B64:  &b64,
+		Crit: []string{"b64"},
+	}
+
+	header, err := json.Marshal(joseHeader)
+	if err != nil {
+		return "", err
+	}
+
+	encodedHeader := base64.RawURLEncoding.EncodeToString(header)
+	input := detachedSigningInput(encodedHeader, &joseHeader, payload)
+
+	signature, err := createSignature(key.Key, key.Alg, input)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s..%s", encodedHeader, base64.RawURLEncoding.EncodeToString(signature)), nil
+}
+
+// VerifyDetached verifies a compact JWS with a detached payload against
+// the payload, which may be unencoded ("b64": false) or base64url encoded
+func VerifyDetached(jws string, payload []byte, opts VerifyOpts) (*JoseHeader, error) {
+	segments := strings.Split(jws, ".")
+	if len(segments) != 3 || segments[1] != "" {
+		return nil, verificationError(ErrMalformedToken, "not detached JWS")
+	}
+
+	jwksUrl, err := resolveJwksUrl(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	joseHeader, err := parseJoseHeader(segments[0], []string{"b64"})
+	if err != nil {
+		return nil, err
+	}
+
+	if joseHeader.B64 != nil && !*joseHeader.B64 && !Contains(joseHeader.Crit, "b64") {
+		return nil, &VerificationError{Err: ErrInvalidHeader, Claim: "crit", Detail: "b64 must be critical"}
+	}
+
+	err = verifyHeader(joseHeader, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	decodedSignature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil {
+		return nil, verificationError(ErrMalformedToken, "unable to decode signature")
+	}
+
+	input := detachedSigningInput(segments[0], joseHeader, payload)
+	_, err = verifyJwsSignature(joseHeader, jwksUrl, input, decodedSignature, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return joseHeader, nil
+}
+
+func detachedSigningInput(encodedHeader string, header *JoseHeader, payload []byte) string {
+	if header.B64 != nil && !*header.B64 {
+		return encodedHeader + "." + string(payload)
+	}
+
+	return encodedHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+}
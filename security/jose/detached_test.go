@@ -0,0 +1,77 @@
+package jose
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSignVerifyDetached(t *testing.T) {
+	km, err := NewKeyManager(KeyManagerOpts{Alg: "PS256"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwks, _ := km.PublicJwkSet()
+		json.NewEncoder(w).Encode(jwks)
+	}))
+
+	defer ts.Close()
+
+	opts := VerifyOpts{Issuer: ts.URL + "/"}
+	body := []byte(`{"event":"user.created","id":"a.b.c"}`)
+
+	jws, err := km.SignDetached(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segments := strings.Split(jws, ".")
+	if len(segments) != 3 || segments[1] != "" {
+		t.Fatalf(`SignDetached() = %q, want detached JWS`, jws)
+	}
+
+	header, err := VerifyDetached(jws, body, opts)
+	if err != nil || header.B64 == nil || *header.B64 {
+		t.Fatalf(`VerifyDetached() = %+v, %v, want match for b64 false header, nil`, header, err)
+	}
+
+	_, err = VerifyDetached(jws, []byte(`{"event":"user.deleted"}`), opts)
+	if err == nil {
+		t.Fatalf(`VerifyDetached() = _, nil, want error for modified body`)
+	}
+}
+
+func TestVerifyDetachedB64NotCritical(t *testing.T) {
+	key, err := GenerateSigningKey("ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoder := base64.RawURLEncoding.EncodeToString
+	header := encoder([]byte(`{"alg":"ES256","kid":"` + key.Kid + `","b64":false}`))
+	body := []byte("payload")
+
+	signature, err := createSignature(key.Key, "ES256", header+"."+string(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jws := header + ".." + encoder(signature)
+	_, err = VerifyDetached(jws, body, VerifyOpts{Issuer: "https://issuer/"})
+	if !errors.Is(err, ErrInvalidHeader) {
+		t.Fatalf(`VerifyDetached() = _, %v, want match for %v`, err, ErrInvalidHeader)
+	}
+}
+
+func TestVerifyDetachedAttachedPayload(t *testing.T) {
+	_, err := VerifyDetached("header.payload.signature", []byte("payload"), VerifyOpts{Issuer: "https://issuer/"})
+	if !errors.Is(err, ErrMalformedToken) {
+		t.Fatalf(`VerifyDetached() = _, %v, want match for %v`, err, ErrMalformedToken)
+	}
+}
@@ -0,0 +1,212 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DpopOpts describes the request a DPoP proof (RFC 9449) must be bound to
+type DpopOpts struct {
+	// Method and Url of the HTTP request the proof was sent with
+	Method string
+	Url    string
+
+	// AccessToken the proof must carry the "ath" hash of, not checked
+	// when empty
+	AccessToken string
+
+	// Nonces currently issued by the server, the proof "nonce" must be
+	// one of them when not empty
+	Nonces []string
+
+	// MaxAge of the proof "iat"
+	MaxAge time.Duration
+	// Leeway is the clock skew tolerated when comparing iat
+	Leeway time.Duration
+
+	Algorithms []string
+}
+
+// DpopProof is a verified DPoP proof
+type DpopProof struct {
+	// Jkt is the SHA-256 JWK thumbprint of the proof key, the value
+	// bound tokens carry in "cnf.jkt"
+	Jkt    string
+	Claims *Claims
+}
+
+// ErrDpopNonce is returned when the proof lacks a current server nonce
+var ErrDpopNonce = errors.New("dpop nonce required")
+
+// VerifyDpopProof verifies a DPoP proof JWT signed by the key embedded
+// in its own "jwk" header and bound to the request described by opts
+func VerifyDpopProof(proof string, opts DpopOpts) (*DpopProof, error) {
+	segments := strings.Split(proof, ".")
+	if len(segments) != 3 {
+		return nil, verificationError(ErrInvalidDpopProof, "incompatible dpop proof detected (not JWS compact)")
+	}
+
+	header, err := parseJoseHeader(segments[0], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(header.Typ, "dpop+jwt") {
+		return nil, verificationError(ErrInvalidDpopProof, "invalid dpop proof type (not dpop+jwt)")
+	}
+
+	err = verifyAlgorithm(header.Alg, opts.Algorithms)
+	if err != nil {
+		return nil, err
+	}
+
+	jwk := header.Jwk
+	if jwk == nil || jwk.IsPrivate() || len(jwk.X5C) > 0 {
+		return nil, verificationError(ErrInvalidDpopProof, "dpop proof requires a public jwk header")
+	}
+
+	err = verifyKeyAlgorithm(jwk, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := publicKeyFromJwk(jwk)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil {
+		return nil, verificationError(ErrInvalidDpopProof, "unable to decode dpop proof signature")
+	}
+
+	err = verifySignature(publicKey, header.Alg, segments[0]+"."+segments[1], signature)
+	if err != nil {
+		return nil, err
+	}
+
+	decodedPayload, err := base64.RawURLEncoding.DecodeString(segments[1])
+	if err != nil {
+		return nil, verificationError(ErrInvalidDpopProof, "unable to decode dpop proof payload")
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(decodedPayload, &claims); err != nil {
+		return nil, verificationError(ErrInvalidDpopProof, "unable to parse dpop proof payload")
+	}
+
+	err = validateDpopClaims(&claims, opts, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	jkt, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DpopProof{Jkt: jkt, Claims: &claims}, nil
+}
+
+// AccessTokenHash returns the "ath" value of an access token
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func validateDpopClaims(claims *Claims, opts DpopOpts, now time.Time) error {
+	if claims.Jti == "" || claims.Iat == 0 {
+		return verificationError(ErrInvalidDpopProof, "dpop proof requires jti and iat")
+	}
+
+	htm, _ := claims.String("htm")
+	if htm != opts.Method {
+		return verificationError(ErrInvalidDpopProof, "dpop proof htm does not match request method")
+	}
+
+	htu, _ := claims.String("htu")
+	if !matchesHtu(htu, opts.Url) {
+		return verificationError(ErrInvalidDpopProof, "dpop proof htu does not match request url")
+	}
+
+	if now.Add(opts.Leeway).Before(claims.IssuedAt()) {
+		return verificationError(ErrInvalidDpopProof, "dpop proof issued in the future")
+	}
+
+	if opts.MaxAge > 0 && now.After(claims.IssuedAt().Add(opts.MaxAge+opts.Leeway)) {
+		return verificationError(ErrInvalidDpopProof, "dpop proof too old")
+	}
+
+	if opts.AccessToken != "" {
+		ath, _ := claims.String("ath")
+		expected := AccessTokenHash(opts.AccessToken)
+		if subtle.ConstantTimeCompare([]byte(ath), []byte(expected)) != 1 {
+			return verificationError(ErrInvalidDpopProof, "dpop proof ath does not match access token")
+		}
+	}
+
+	if len(opts.Nonces) > 0 {
+		nonce, _ := claims.String("nonce")
+		if nonce == "" || !Contains(opts.Nonces, nonce) {
+			return ErrDpopNonce
+		}
+	}
+
+	return nil
+}
+
+// matchesHtu compares URIs without their query and fragment parts
+// (RFC 9449 section 4.3) after scheme and host normalization
+func matchesHtu(htu string, requestUrl string) bool {
+	normalizedHtu, err := normalizeHtu(htu)
+	if err != nil {
+		return false
+	}
+
+	normalizedUrl, err := normalizeHtu(requestUrl)
+	if err != nil {
+		return false
+	}
+
+	return normalizedHtu == normalizedUrl
+}
+
+func normalizeHtu(rawUrl string) (string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return "", errors.New("absolute url required")
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (scheme == "https" && port == "443") || (scheme == "http" && port == "80") {
+		port = ""
+	}
+
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	if port != "" {
+		host = host + ":" + port
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	return scheme + "://" + host + path, nil
+}
@@ -0,0 +1,94 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func signDpopProof(t *testing.T, key *ecdsa.PrivateKey, header JoseHeader, claims map[string]interface{}) string {
+	encoder := base64.RawURLEncoding.EncodeToString
+
+	encodedHeader, _ := json.Marshal(header)
+	payload, _ := json.Marshal(claims)
+	input := encoder(encodedHeader) + "." + encoder(payload)
+
+	signature, err := createSignature(key, header.Alg, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return input + "." + encoder(signature)
+}
+
+func TestVerifyDpopProof(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk, _ := NewJwk(key.Public())
+	privateJwk, _ := NewJwk(key)
+	jkt, _ := jwk.Thumbprint(crypto.SHA256)
+
+	opts := DpopOpts{
+		Method:      "GET",
+		Url:         "https://api.example.com:443/db/users?page=1",
+		AccessToken: "access-token",
+		Nonces:      []string{"n1"},
+		MaxAge:      time.Minute,
+	}
+
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"jti":   "j1",
+			"htm":   "GET",
+			"htu":   "https://API.example.com/db/users",
+			"iat":   time.Now().Unix(),
+			"ath":   AccessTokenHash("access-token"),
+			"nonce": "n1",
+		}
+		for k, v := range changes {
+			c[k] = v
+		}
+		return c
+	}
+
+	header := JoseHeader{Alg: "ES256", Typ: "dpop+jwt", Jwk: jwk}
+
+	proof, err := VerifyDpopProof(signDpopProof(t, key, header, claims(nil)), opts)
+	if err != nil || proof.Jkt != jkt {
+		t.Fatalf(`VerifyDpopProof() = %+v, %v, want match for jkt %s, nil`, proof, err, jkt)
+	}
+
+	testCases := []struct {
+		name   string
+		header JoseHeader
+		claims map[string]interface{}
+	}{
+		{"wrong typ", JoseHeader{Alg: "ES256", Typ: "JWT", Jwk: jwk}, claims(nil)},
+		{"no jwk", JoseHeader{Alg: "ES256", Typ: "dpop+jwt"}, claims(nil)},
+		{"private jwk", JoseHeader{Alg: "ES256", Typ: "dpop+jwt", Jwk: privateJwk}, claims(nil)},
+		{"wrong htm", header, claims(map[string]interface{}{"htm": "POST"})},
+		{"wrong htu", header, claims(map[string]interface{}{"htu": "https://api.example.com/mgmt/users"})},
+		{"old iat", header, claims(map[string]interface{}{"iat": time.Now().Add(-time.Hour).Unix()})},
+		{"future iat", header, claims(map[string]interface{}{"iat": time.Now().Add(time.Hour).Unix()})},
+		{"wrong ath", header, claims(map[string]interface{}{"ath": AccessTokenHash("other")})},
+		{"missing jti", header, claims(map[string]interface{}{"jti": ""})},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proof, err := VerifyDpopProof(signDpopProof(t, key, tc.header, tc.claims), opts)
+			if err == nil {
+				t.Fatalf(`VerifyDpopProof() = %+v, nil, want error`, proof)
+			}
+		})
+	}
+
+	_, err = VerifyDpopProof(signDpopProof(t, key, header, claims(map[string]interface{}{"nonce": "stale"})), opts)
+	if err != ErrDpopNonce {
+		t.Fatalf(`VerifyDpopProof(stale nonce) = _, %v, want match for %v`, err, ErrDpopNonce)
+	}
+}
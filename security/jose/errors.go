@@ -0,0 +1,141 @@
+package jose
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Verification failures wrap one of these, test for them with errors.Is
+var (
+	ErrMalformedToken      = errors.New("malformed token")
+	ErrInvalidHeader       = errors.New("invalid token header")
+	ErrAlgorithmNotAllowed = errors.New("algorithm not allowed")
+	ErrInvalidTokenType    = errors.New("invalid token type")
+	ErrUnknownKid          = errors.New("unable to find key")
+	ErrKeyUnavailable      = errors.New("keys temporarily unavailable")
+	ErrKeyNotAllowed       = errors.New("key not allowed")
+	ErrInvalidCertificate  = errors.New("invalid certificate")
+	ErrInvalidSignature    = errors.New("invalid signature")
+	ErrTokenExpired        = errors.New("token expired")
+	ErrTokenNotYetValid    = errors.New("token not yet valid")
+	ErrTokenTooOld         = errors.New("token exceeds maximum age")
+	ErrInvalidIssuer       = errors.New("invalid issuer")
+	ErrInvalidAudience     = errors.New("invalid audience")
+	ErrMissingClaim        = errors.New("missing claim")
+	ErrInvalidDpopProof    = errors.New("invalid dpop proof")
+	ErrDecryptionFailed    = errors.New("unable to decrypt token")
+)
+
+var sentinels = []error{
+	ErrMalformedToken, ErrInvalidHeader, ErrAlgorithmNotAllowed, ErrInvalidTokenType,
+	ErrUnknownKid, ErrKeyUnavailable, ErrKeyNotAllowed, ErrInvalidCertificate,
+	ErrInvalidSignature, ErrTokenExpired, ErrTokenNotYetValid, ErrTokenTooOld,
+	ErrInvalidIssuer, ErrInvalidAudience, ErrMissingClaim, ErrInvalidDpopProof,
+	ErrDecryptionFailed,
+}
+
+// VerificationError describes why a token was rejected, Err is one of
+// the sentinel errors above
+type VerificationError struct {
+	Err error
+	// Claim or header parameter at fault, when there is one
+	Claim  string
+	Detail string
+}
+
+func (e *VerificationError) Error() string {
+	if e.Detail == "" {
+		return e.Err.Error()
+	}
+
+	return fmt.Sprintf("%s: %s", e.Err, e.Detail)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+func verificationError(err error, format string, args ...interface{}) error {
+	return &VerificationError{Err: err, Detail: fmt.Sprintf(format, args...)}
+}
+
+func claimError(err error, claim string) error {
+	return &VerificationError{Err: err, Claim: claim, Detail: claim}
+}
+
+// asVerificationError keeps errors that are already classified and
+// classifies the others as err
+func asVerificationError(err error, sentinel error) error {
+	var verr *VerificationError
+	if errors.As(err, &verr) || isSentinel(err) {
+		return err
+	}
+
+	return &VerificationError{Err: sentinel, Detail: err.Error()}
+}
+
+func isSentinel(err error) bool {
+	for _, sentinel := range sentinels {
+		if errors.Is(err, sentinel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// joinErrors combines the errors so errors.Is and errors.As match any of
+// them, as errors.Join does from Go 1.20
+func joinErrors(errs ...error) error {
+	joined := joinError{}
+	for _, err := range errs {
+		if err != nil {
+			joined = append(joined, err)
+		}
+	}
+
+	switch len(joined) {
+	case 0:
+		return nil
+	case 1:
+		return joined[0]
+	default:
+		return joined
+	}
+}
+
+type joinError []error
+
+func (e joinError) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+func (e joinError) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e joinError) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e joinError) Unwrap() []error {
+	return e
+}
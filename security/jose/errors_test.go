@@ -0,0 +1,155 @@
+package jose
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVerificationErrors(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherRsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk, _ := NewJwk(key.Public())
+	jwk.Kid = "kid1"
+	rsaJwk, _ := NewJwk(rsaKey.Public())
+	rsaJwk.Kid = "rsa1"
+	keys := NewStaticKeySource(&JwkSet{Keys: []Jwk{*jwk, *rsaJwk}})
+
+	claims := func(exp time.Duration, aud string) map[string]interface{} {
+		return map[string]interface{}{
+			"iss": "https://issuer/",
+			"aud": aud,
+			"exp": time.Now().Add(exp).Unix(),
+		}
+	}
+
+	testCases := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"malformed", "not.a-token", ErrMalformedToken},
+		{"unknown kid", signCompact(t, key, "ES256", "kid2", claims(time.Hour, "audience")), ErrUnknownKid},
+		{"bad signature", signCompact(t, otherKey, "ES256", "kid1", claims(time.Hour, "audience")), ErrInvalidSignature},
+		{"bad signature rs256", signCompact(t, otherRsaKey, "RS256", "rsa1", claims(time.Hour, "audience")), ErrInvalidSignature},
+		{"bad signature ps256", signCompact(t, otherRsaKey, "PS256", "rsa1", claims(time.Hour, "audience")), ErrInvalidSignature},
+		{"expired", signCompact(t, key, "ES256", "kid1", claims(-time.Hour, "audience")), ErrTokenExpired},
+		{"wrong audience", signCompact(t, key, "ES256", "kid1", claims(time.Hour, "other")), ErrInvalidAudience},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := VerifyCompact(tc.token, VerifyOpts{Issuer: "https://issuer/", Audience: "audience", Keys: keys})
+			if !errors.Is(err, tc.want) {
+				t.Fatalf(`VerifyCompact(%s) = %v, want match for %v`, tc.name, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestVerificationErrorClaim(t *testing.T) {
+	err := validateClaims(&Claims{Iss: "https://issuer/"}, VerifyOpts{Issuer: "https://issuer/"}, time.Now())
+
+	var verr *VerificationError
+	if !errors.As(err, &verr) || verr.Claim != "exp" || !errors.Is(err, ErrMissingClaim) {
+		t.Fatalf(`validateClaims() = %v, want match for missing exp claim`, err)
+	}
+}
+
+func TestVerifySignatureErrors(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name string
+		alg  string
+		want error
+	}{
+		{"rs256", "RS256", ErrInvalidSignature},
+		{"ps256", "PS256", ErrInvalidSignature},
+		{"unsupported", "XS256", ErrAlgorithmNotAllowed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifySignature(rsaKey.Public(), tc.alg, "input", []byte("signature"))
+
+			var verr *VerificationError
+			if !errors.Is(err, tc.want) || !errors.As(err, &verr) {
+				t.Fatalf(`verifySignature(%q) = %v, want match for %v`, tc.alg, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestVerificationErrorKeysUnavailable(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	defer ts.Close()
+
+	claims := map[string]interface{}{
+		"iss": "https://issuer/",
+		"aud": "audience",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	// the issuer keys cannot be fetched, the token itself may be valid
+	token := signCompact(t, key, "ES256", "kid1", claims)
+	opts := VerifyOpts{Issuer: "https://issuer/", Audience: "audience", JwksUrl: ts.URL + "/jwks.json"}
+
+	_, err = VerifyCompact(token, opts)
+	if !errors.Is(err, ErrKeyUnavailable) {
+		t.Fatalf(`VerifyCompact() = %v, want match for %v`, err, ErrKeyUnavailable)
+	}
+}
+
+func TestJoinErrors(t *testing.T) {
+	if joinErrors(nil, nil) != nil {
+		t.Fatalf(`joinErrors(nil, nil) != nil, want match for nil`)
+	}
+
+	single := verificationError(ErrInvalidSignature, "detail")
+	if joinErrors(nil, single) != single {
+		t.Fatalf(`joinErrors(nil, err) != err, want match for err`)
+	}
+
+	err := joinErrors(errors.New("first"), single)
+
+	var verr *VerificationError
+	if !errors.Is(err, ErrInvalidSignature) || !errors.As(err, &verr) || err.Error() != "first; invalid signature: detail" {
+		t.Fatalf(`joinErrors() = %v, want match for both errors`, err)
+	}
+}
@@ -0,0 +1,241 @@
+package jose
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// HeaderKeyPolicy opts in to keys referenced (jku, x5u) or embedded
+// (jwk, x5c) in the JOSE header instead of the issuer JWKS
+type HeaderKeyPolicy struct {
+	// AllowedUrls are the URL prefixes jku and x5u may point to
+	AllowedUrls []string
+	// TrustAnchors every certificate based key (x5c, x5u, or a jwk with
+	// x5c) must chain to, embedded keys are refused without them
+	TrustAnchors *x509.CertPool
+}
+
+// registeredHeaderNames are defined by RFC 7515 and RFC 7516 and so may
+// never be listed in "crit"
+var registeredHeaderNames = []string{
+	"alg", "jku", "jwk", "kid", "x5u", "x5c", "x5t", "x5t#S256", "typ", "cty", "crit",
+	"enc", "zip", "epk", "apu", "apv", "iv", "tag", "p2s", "p2c",
+}
+
+// parseJoseHeader decodes a protected header and applies the "crit"
+// rules of RFC 7515 section 4.1.11 given the extensions understood by
+// the caller
+func parseJoseHeader(encodedHeader string, understood []string) (*JoseHeader, error) {
+	decodedHeader, err := base64.RawURLEncoding.DecodeString(encodedHeader)
+	if err != nil {
+		return nil, verificationError(ErrMalformedToken, "unable to decode token header")
+	}
+
+	if !utf8.Valid(decodedHeader) {
+		return nil, verificationError(ErrMalformedToken, "not a valid UTF-8 encoded sequence")
+	}
+
+	members := map[string]interface{}{}
+	err = json.Unmarshal(decodedHeader, &members)
+	if err != nil {
+		return nil, verificationError(ErrMalformedToken, "unable to parse token header")
+	}
+
+	err = checkCritical(members, understood)
+	if err != nil {
+		return nil, err
+	}
+
+	var joseHeader JoseHeader
+	err = json.Unmarshal(decodedHeader, &joseHeader)
+	if err != nil {
+		return nil, verificationError(ErrMalformedToken, "unable to parse token header")
+	}
+
+	return &joseHeader, nil
+}
+
+func checkCritical(members map[string]interface{}, understood []string) error {
+	value, ok := members["crit"]
+	if !ok {
+		return nil
+	}
+
+	list, ok := value.([]interface{})
+	if !ok || len(list) == 0 {
+		return verificationError(ErrInvalidHeader, "crit must be a non-empty array")
+	}
+
+	for _, item := range list {
+		name, ok := item.(string)
+		if !ok || name == "" {
+			return verificationError(ErrInvalidHeader, "crit must list header parameter names")
+		}
+
+		if Contains(registeredHeaderNames, name) {
+			return verificationError(ErrInvalidHeader, "crit lists registered header parameter: %s", name)
+		}
+
+		if _, ok := members[name]; !ok {
+			return verificationError(ErrInvalidHeader, "critical header parameter missing: %s", name)
+		}
+
+		if !Contains(understood, name) {
+			return verificationError(ErrInvalidHeader, "critical header parameter not understood: %s", name)
+		}
+	}
+
+	return nil
+}
+
+// resolveJwk returns the key that verifies a signature, from the header
+// when the policy allows it, otherwise from the issuer keys
+func resolveJwk(header *JoseHeader, keys KeySource, policy *HeaderKeyPolicy) (*Jwk, error) {
+	switch {
+	case header.Jwk != nil || len(header.X5C) > 0 || header.X5U != "":
+		return resolveCertificateJwk(header, policy)
+	case header.Jku != "":
+		if policy == nil || !policy.allowsUrl(header.Jku) {
+			return nil, verificationError(ErrKeyNotAllowed, "jku not allowed")
+		}
+
+		return fetchJwk(header.Jku, header.Kid)
+	default:
+		return keys.Key(header.Kid)
+	}
+}
+
+func resolveCertificateJwk(header *JoseHeader, policy *HeaderKeyPolicy) (*Jwk, error) {
+	if policy == nil || policy.TrustAnchors == nil {
+		return nil, verificationError(ErrKeyNotAllowed, "header keys not allowed")
+	}
+
+	x5c := header.X5C
+	switch {
+	case header.Jwk != nil:
+		x5c = header.Jwk.X5C
+	case header.X5U != "":
+		if !policy.allowsUrl(header.X5U) {
+			return nil, verificationError(ErrKeyNotAllowed, "x5u not allowed")
+		}
+
+		var err error
+		x5c, err = fetchCertificateChain(header.X5U)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(x5c) == 0 {
+		return nil, verificationError(ErrKeyNotAllowed, "header key does not chain to a trust anchor")
+	}
+
+	leaf, err := verifyCertificateChain(x5c, policy.TrustAnchors, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	jwk, err := NewJwk(leaf.PublicKey)
+	if err != nil {
+		return nil, verificationError(ErrKeyNotAllowed, "%v", err)
+	}
+
+	if header.Jwk != nil && (header.Jwk.Alg != "" || header.Jwk.Use != "") {
+		jwk.Alg = header.Jwk.Alg
+		jwk.Use = header.Jwk.Use
+	}
+
+	jwk.X5C = x5c
+
+	return jwk, nil
+}
+
+// allowsUrl matches a jku or x5u against the allowlisted prefixes by
+// scheme, host and path so "https://a.com/keys" does not allow
+// "https://a.com.evil.com/keys" nor "https://a.com/keys-evil/x.json"
+func (policy *HeaderKeyPolicy) allowsUrl(rawUrl string) bool {
+	target, err := url.Parse(rawUrl)
+	if err != nil || target.User != nil {
+		return false
+	}
+
+	targetPath, ok := cleanUrlPath(target)
+	if !ok {
+		return false
+	}
+
+	for _, allowed := range policy.AllowedUrls {
+		prefix, err := url.Parse(allowed)
+		if err != nil {
+			continue
+		}
+
+		prefixPath, ok := cleanUrlPath(prefix)
+		if !ok {
+			continue
+		}
+
+		if target.Scheme == prefix.Scheme && target.Host == prefix.Host &&
+			isWithinPath(targetPath, prefixPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cleanUrlPath returns the unescaped path of u, refusing dot segments
+// before and after unescaping so "/jwks/../uploads" and its %2e%2e form
+// cannot escape an allowlisted directory
+func cleanUrlPath(u *url.URL) (string, bool) {
+	escaped := u.EscapedPath()
+
+	unescaped, err := url.PathUnescape(escaped)
+	if err != nil || strings.Contains(unescaped, "\\") {
+		return "", false
+	}
+
+	if hasDotSegment(escaped) || hasDotSegment(unescaped) {
+		return "", false
+	}
+
+	return path.Clean("/" + unescaped), true
+}
+
+func hasDotSegment(p string) bool {
+	for _, segment := range strings.Split(p, "/") {
+		if segment == "." || segment == ".." {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isWithinPath requires a segment boundary, the prefix itself or a path
+// below it
+func isWithinPath(target string, prefix string) bool {
+	if prefix == "/" {
+		return true
+	}
+
+	return target == prefix || strings.HasPrefix(target, prefix+"/")
+}
+
+// Contains reports whether value is one of values, such as an algorithm
+// or a header parameter name
+func Contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
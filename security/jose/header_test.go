@@ -0,0 +1,135 @@
+package jose
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseJoseHeaderCritical(t *testing.T) {
+	testCases := []struct {
+		name       string
+		header     string
+		understood []string
+		valid      bool
+	}{
+		{"no crit", `{"alg":"ES256"}`, nil, true},
+		{"understood", `{"alg":"ES256","b64":false,"crit":["b64"]}`, []string{"b64"}, true},
+		{"not understood", `{"alg":"ES256","b64":false,"crit":["b64"]}`, nil, false},
+		{"unknown extension", `{"alg":"ES256","exp":1,"crit":["exp"]}`, []string{"b64"}, false},
+		{"missing parameter", `{"alg":"ES256","crit":["b64"]}`, []string{"b64"}, false},
+		{"registered parameter", `{"alg":"ES256","crit":["alg"]}`, []string{"alg"}, false},
+		{"empty list", `{"alg":"ES256","crit":[]}`, nil, false},
+		{"not a list", `{"alg":"ES256","crit":"b64"}`, []string{"b64"}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded := base64.RawURLEncoding.EncodeToString([]byte(tc.header))
+			_, err := parseJoseHeader(encoded, tc.understood)
+			if tc.valid != (err == nil) {
+				t.Fatalf(`parseJoseHeader(%s) = _, %v, want valid %t`, tc.header, err, tc.valid)
+			}
+		})
+	}
+}
+
+func TestHeaderKeyPolicyAllowsUrl(t *testing.T) {
+	policy := &HeaderKeyPolicy{
+		AllowedUrls: []string{"https://keys.example.com/jwks/"},
+	}
+
+	testCases := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://keys.example.com/jwks/tenant.json", true},
+		{"http://keys.example.com/jwks/tenant.json", false},
+		{"https://keys.example.com.evil.com/jwks/tenant.json", false},
+		{"https://keys.example.com/other/tenant.json", false},
+		{"https://user@keys.example.com/jwks/tenant.json", false},
+		{"https://keys.example.com/jwks/../uploads/evil.json", false},
+		{"https://keys.example.com/jwks/./tenant.json", false},
+		{"https://keys.example.com/jwks/%2e%2e/uploads/evil.json", false},
+		{"https://keys.example.com/jwks/%2E%2E/uploads/evil.json", false},
+		{"https://keys.example.com/jwks%2f..%2fuploads/evil.json", false},
+		{"https://keys.example.com/jwks/..%5cuploads/evil.json", false},
+		{"https://keys.example.com/jwks-evil/x.json", false},
+		{"https://keys.example.com/jwks", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.url, func(t *testing.T) {
+			allowed := policy.allowsUrl(tc.url)
+			if allowed != tc.allowed {
+				t.Fatalf(`allowsUrl(%q) = %t, want match for %t`, tc.url, allowed, tc.allowed)
+			}
+		})
+	}
+}
+
+func TestHeaderKeyPolicyAllowsUrlWithoutTrailingSlash(t *testing.T) {
+	policy := &HeaderKeyPolicy{
+		AllowedUrls: []string{"https://keys.example.com/jwks"},
+	}
+
+	testCases := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://keys.example.com/jwks", true},
+		{"https://keys.example.com/jwks/tenant.json", true},
+		{"https://keys.example.com/jwks-evil/x.json", false},
+		{"https://keys.example.com/jwksevil", false},
+		{"https://keys.example.com/jwks/../uploads/evil.json", false},
+		{"https://keys.example.com/jwks/%2e%2e/uploads/evil.json", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.url, func(t *testing.T) {
+			allowed := policy.allowsUrl(tc.url)
+			if allowed != tc.allowed {
+				t.Fatalf(`allowsUrl(%q) = %t, want match for %t`, tc.url, allowed, tc.allowed)
+			}
+		})
+	}
+}
+
+func TestResolveJwkHeaderKeys(t *testing.T) {
+	ca := newTestCertificateAuthority(t)
+	leaf, leafDer := ca.issue(t)
+	encodedDer := base64.StdEncoding.EncodeToString(leafDer)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	policy := &HeaderKeyPolicy{TrustAnchors: roots}
+
+	other := newTestCertificateAuthority(t)
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(other.cert)
+
+	leafJwk, _ := NewJwk(leaf.Public())
+
+	testCases := []struct {
+		name   string
+		header JoseHeader
+		policy *HeaderKeyPolicy
+		valid  bool
+	}{
+		{"x5c chained", JoseHeader{Alg: "ES256", X5C: []string{encodedDer}}, policy, true},
+		{"x5c without policy", JoseHeader{Alg: "ES256", X5C: []string{encodedDer}}, nil, false},
+		{"x5c untrusted", JoseHeader{Alg: "ES256", X5C: []string{encodedDer}}, &HeaderKeyPolicy{TrustAnchors: otherRoots}, false},
+		{"bare jwk", JoseHeader{Alg: "ES256", Jwk: leafJwk}, policy, false},
+		{"jwk with x5c", JoseHeader{Alg: "ES256", Jwk: &Jwk{Kty: "EC", X5C: []string{encodedDer}}}, policy, true},
+		{"jku not allowlisted", JoseHeader{Alg: "ES256", Jku: "https://attacker.example.com/jwks"}, policy, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			jwk, err := resolveJwk(&tc.header, NewUrlKeySource("https://issuer/jwks"), tc.policy)
+			if tc.valid != (err == nil) {
+				t.Fatalf(`resolveJwk() = %+v, %v, want valid %t`, jwk, err, tc.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"fmt"
+)
+
+// HmacAlgorithms are the shared secret algorithms, only accepted from
+// issuers configured with secrets
+var HmacAlgorithms = []string{"HS256", "HS384", "HS512"}
+
+// HmacSecret verifies HS256/HS384/HS512 tokens of one issuer
+type HmacSecret struct {
+	// Audience the secret belongs to, it is only used when VerifyOpts
+	// requires that audience (any audience when empty)
+	Audience string
+	Secret   []byte
+}
+
+// verifyHmacAlgorithm is the symmetric counterpart of verifyAlgorithm, an
+// issuer with secrets accepts HMAC algorithms and nothing else
+func verifyHmacAlgorithm(alg string, allowed []string) error {
+	if !Contains(HmacAlgorithms, alg) {
+		return verificationError(ErrAlgorithmNotAllowed, "%s", alg)
+	}
+
+	if len(allowed) > 0 && !Contains(allowed, alg) {
+		return verificationError(ErrAlgorithmNotAllowed, "%s", alg)
+	}
+
+	return nil
+}
+
+// verifyHmacSignature checks the signature with each secret configured for
+// the audience, secrets are never derived from a JWK so a public key
+// cannot be turned into an HMAC secret. The signer is the position of the
+// secret that verified it
+func verifyHmacSignature(alg string, signingInput string, signature []byte, secrets []HmacSecret, audience string) (string, error) {
+	hash, err := hmacHash(alg)
+	if err != nil {
+		return "", err
+	}
+
+	found := false
+	for i, secret := range secrets {
+		if secret.Audience != "" && secret.Audience != audience {
+			continue
+		}
+
+		// RFC 7518 section 3.2, the key must be at least as long as the hash
+		if len(secret.Secret) < hash.Size() {
+			continue
+		}
+
+		found = true
+
+		mac := hmac.New(hash.New, secret.Secret)
+		mac.Write([]byte(signingInput))
+		if hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Sprintf("hmac:%d", i), nil
+		}
+	}
+
+	if !found {
+		return "", verificationError(ErrKeyNotAllowed, "no secret for audience and algorithm")
+	}
+
+	return "", ErrInvalidSignature
+}
+
+// HmacKeySize is the shortest secret RFC 7518 section 3.2 allows for the
+// algorithm, the size of its hash output
+func HmacKeySize(alg string) (int, error) {
+	hash, err := hmacHash(alg)
+	if err != nil {
+		return 0, err
+	}
+
+	return hash.Size(), nil
+}
+
+func hmacHash(alg string) (crypto.Hash, error) {
+	switch alg {
+	case "HS256":
+		return crypto.SHA256, nil
+	case "HS384":
+		return crypto.SHA384, nil
+	case "HS512":
+		return crypto.SHA512, nil
+	default:
+		return crypto.SHA256, verificationError(ErrAlgorithmNotAllowed, "%s", alg)
+	}
+}
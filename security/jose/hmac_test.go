@@ -0,0 +1,153 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifyCompactHmac(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	otherSecret := []byte("fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210")
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := map[string]interface{}{
+		"iss": "https://issuer/",
+		"aud": "audience",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	opts := VerifyOpts{
+		Issuer:   "https://issuer/",
+		Audience: "audience",
+		Secrets:  []HmacSecret{{Audience: "audience", Secret: secret}},
+	}
+
+	testCases := []struct {
+		name  string
+		token string
+		opts  func(o *VerifyOpts)
+		want  error
+	}{
+		{"hs256", signHmac(t, secret, "HS256", claims), func(o *VerifyOpts) {}, nil},
+		{"hs384", signHmac(t, secret, "HS384", claims), func(o *VerifyOpts) {}, nil},
+		{"hs512", signHmac(t, secret, "HS512", claims), func(o *VerifyOpts) {}, nil},
+		{"rotated secret", signHmac(t, secret, "HS256", claims), func(o *VerifyOpts) {
+			o.Secrets = []HmacSecret{{Secret: otherSecret}, {Secret: secret}}
+		}, nil},
+		{"wrong secret", signHmac(t, otherSecret, "HS256", claims), func(o *VerifyOpts) {}, ErrInvalidSignature},
+		{"secret of other audience", signHmac(t, secret, "HS256", claims), func(o *VerifyOpts) {
+			o.Secrets = []HmacSecret{{Audience: "other", Secret: secret}}
+		}, ErrKeyNotAllowed},
+		{"secret too short", signHmac(t, secret[:16], "HS256", claims), func(o *VerifyOpts) {
+			o.Secrets = []HmacSecret{{Secret: secret[:16]}}
+		}, ErrKeyNotAllowed},
+		{"not allowlisted", signHmac(t, secret, "HS512", claims), func(o *VerifyOpts) {
+			o.Algorithms = []string{"HS256"}
+		}, ErrAlgorithmNotAllowed},
+		{"asymmetric token", signCompact(t, rsaKey, "RS256", "kid1", claims), func(o *VerifyOpts) {}, ErrAlgorithmNotAllowed},
+		{"issuer without secrets", signHmac(t, secret, "HS256", claims), func(o *VerifyOpts) {
+			o.Secrets = nil
+			o.Keys = NewStaticKeySource(&JwkSet{Keys: []Jwk{}})
+		}, ErrAlgorithmNotAllowed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tcOpts := opts
+			tc.opts(&tcOpts)
+
+			_, err := VerifyCompact(tc.token, tcOpts)
+			if tc.want == nil && err != nil || tc.want != nil && !errors.Is(err, tc.want) {
+				t.Fatalf(`VerifyCompact(%s) = %v, want match for %v`, tc.name, err, tc.want)
+			}
+		})
+	}
+}
+
+// an RS256 issuer must not accept an HS256 token keyed with its public key
+func TestVerifyCompactHmacPublicKeyConfusion(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk, _ := NewJwk(rsaKey.Public())
+	jwk.Kid = "kid1"
+
+	der, _ := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	claims := map[string]interface{}{
+		"iss": "https://issuer/",
+		"aud": "audience",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	opts := VerifyOpts{
+		Issuer:     "https://issuer/",
+		Audience:   "audience",
+		Keys:       NewStaticKeySource(&JwkSet{Keys: []Jwk{*jwk}}),
+		Algorithms: append([]string{"HS256"}, DefaultAlgorithms...),
+	}
+
+	for _, key := range [][]byte{pemData, der} {
+		_, err := VerifyCompact(signHmac(t, key, "HS256", claims), opts)
+		if !errors.Is(err, ErrAlgorithmNotAllowed) {
+			t.Fatalf(`VerifyCompact(HS256 public key) = %v, want match for %v`, err, ErrAlgorithmNotAllowed)
+		}
+	}
+}
+
+func signHmac(t *testing.T, secret []byte, alg string, claims map[string]interface{}) string {
+	encoder := base64.RawURLEncoding.EncodeToString
+
+	hash := map[string]crypto.Hash{"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512}[alg]
+
+	header, _ := json.Marshal(JoseHeader{Alg: alg, Typ: "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := encoder(header) + "." + encoder(payload)
+
+	mac := hmac.New(hash.New, secret)
+	mac.Write([]byte(input))
+
+	return input + "." + encoder(mac.Sum(nil))
+}
+
+func TestHmacKeySize(t *testing.T) {
+	testCases := []struct {
+		alg   string
+		want  int
+		valid bool
+	}{
+		{"HS256", 32, true},
+		{"HS384", 48, true},
+		{"HS512", 64, true},
+		{"RS256", 0, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.alg, func(t *testing.T) {
+			size, err := HmacKeySize(tc.alg)
+			if tc.valid != (err == nil) || size != tc.want {
+				t.Fatalf(`HmacKeySize() = %d, %v, want match for %d, valid %t`, size, err, tc.want, tc.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package jose
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// UnverifiedIssuer returns the "iss" claim of a JWS compact token without
+// verifying it, it is only meant to select which trusted issuer the token
+// must then be fully verified against
+func UnverifiedIssuer(token string) (string, error) {
+	var payload struct {
+		Iss string `json:"iss"`
+	}
+
+	err := unverifiedPayload(token, &payload)
+	if err != nil {
+		return "", err
+	}
+
+	if payload.Iss == "" {
+		return "", claimError(ErrMissingClaim, "iss")
+	}
+
+	return payload.Iss, nil
+}
+
+// UnverifiedAudience returns the "aud" claim of a JWS compact token
+// without verifying it, it only selects among the audiences trusted for
+// the issuer
+func UnverifiedAudience(token string) ([]string, error) {
+	var payload struct {
+		Aud interface{} `json:"aud"`
+	}
+
+	err := unverifiedPayload(token, &payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if payload.Aud == nil {
+		return nil, claimError(ErrMissingClaim, "aud")
+	}
+
+	aud, err := parseAudience(payload.Aud)
+	if err != nil {
+		return nil, claimError(ErrInvalidAudience, "aud")
+	}
+
+	return aud, nil
+}
+
+// UnverifiedHeader returns the protected header of a JWS compact token
+// without verifying it, e.g. to read the alg of a token verified before
+func UnverifiedHeader(token string) (*JoseHeader, error) {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return nil, verificationError(ErrMalformedToken, "not JWS compact")
+	}
+
+	return parseJoseHeader(segments[0], nil)
+}
+
+func unverifiedPayload(token string, payload interface{}) error {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return verificationError(ErrMalformedToken, "not JWS compact")
+	}
+
+	decodedPayload, err := base64.RawURLEncoding.DecodeString(segments[1])
+	if err != nil {
+		return verificationError(ErrMalformedToken, "unable to decode token payload")
+	}
+
+	err = json.Unmarshal(decodedPayload, payload)
+	if err != nil {
+		return verificationError(ErrMalformedToken, "unable to parse token payload")
+	}
+
+	return nil
+}
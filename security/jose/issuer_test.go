@@ -0,0 +1,60 @@
+package jose
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func TestUnverifiedIssuer(t *testing.T) {
+	encoder := base64.RawURLEncoding.EncodeToString
+	header := encoder([]byte(`{"alg":"RS256"}`))
+
+	testCases := []struct {
+		name  string
+		token string
+		want  string
+		valid bool
+	}{
+		{"issuer", header + "." + encoder([]byte(`{"iss":"https://issuer/"}`)) + ".sig", "https://issuer/", true},
+		{"missing issuer", header + "." + encoder([]byte(`{"sub":"subject"}`)) + ".sig", "", false},
+		{"invalid payload", header + ".!!!.sig", "", false},
+		{"not compact", "opaque-token", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			iss, err := UnverifiedIssuer(tc.token)
+			if tc.valid != (err == nil) || iss != tc.want {
+				t.Fatalf(`UnverifiedIssuer() = %q, %v, want match for %q, valid %t`, iss, err, tc.want, tc.valid)
+			}
+		})
+	}
+}
+
+func TestUnverifiedAudience(t *testing.T) {
+	encoder := base64.RawURLEncoding.EncodeToString
+	header := encoder([]byte(`{"alg":"RS256"}`))
+
+	testCases := []struct {
+		name  string
+		token string
+		want  []string
+		valid bool
+	}{
+		{"audience", header + "." + encoder([]byte(`{"aud":"api"}`)) + ".sig", []string{"api"}, true},
+		{"audiences", header + "." + encoder([]byte(`{"aud":["api","other"]}`)) + ".sig", []string{"api", "other"}, true},
+		{"missing audience", header + "." + encoder([]byte(`{"sub":"subject"}`)) + ".sig", nil, false},
+		{"invalid audience", header + "." + encoder([]byte(`{"aud":1}`)) + ".sig", nil, false},
+		{"not compact", "opaque-token", nil, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			aud, err := UnverifiedAudience(tc.token)
+			if tc.valid != (err == nil) || !reflect.DeepEqual(aud, tc.want) {
+				t.Fatalf(`UnverifiedAudience() = %q, %v, want match for %q, valid %t`, aud, err, tc.want, tc.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,408 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type JweHeader struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+	Cty string `json:"cty"`
+	Zip string `json:"zip"`
+	Epk *Jwk   `json:"epk"`
+	Apu string `json:"apu"`
+	Apv string `json:"apv"`
+
+	// Crit lists extensions that must be understood, none are (RFC 7516
+	// section 4.1.13)
+	Crit []string `json:"crit,omitempty"`
+}
+
+// DecryptionKey is a locally configured key used to decrypt JWEs, Key is
+// an *rsa.PrivateKey (RSA-OAEP-256), an *ecdsa.PrivateKey (ECDH-ES) or a
+// []byte content encryption key (dir)
+type DecryptionKey struct {
+	Kid string
+	Key interface{}
+}
+
+// IsCompactJwe reports whether the token uses the five segment
+// JWE Compact Serialization format
+func IsCompactJwe(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// DecryptCompact returns the plaintext of a JWE using the
+// JWE Compact Serialization format.
+func DecryptCompact(token string, keys []DecryptionKey) ([]byte, *JweHeader, error) {
+	segments := strings.Split(token, ".")
+	if len(segments) != 5 {
+		return nil, nil, verificationError(ErrMalformedToken, "not JWE compact")
+	}
+
+	decoder := base64.RawURLEncoding.DecodeString
+
+	header := segments[0]
+	decodedHeader, err := decoder(header)
+	if err != nil {
+		return nil, nil, verificationError(ErrMalformedToken, "unable to decode token header")
+	}
+
+	var jweHeader JweHeader
+	err = json.Unmarshal(decodedHeader, &jweHeader)
+	if err != nil {
+		return nil, nil, verificationError(ErrInvalidHeader, "unable to parse token header")
+	}
+
+	members := map[string]interface{}{}
+	err = json.Unmarshal(decodedHeader, &members)
+	if err != nil {
+		return nil, nil, verificationError(ErrInvalidHeader, "unable to parse token header")
+	}
+
+	err = checkCritical(members, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if jweHeader.Zip != "" {
+		return nil, nil, &VerificationError{Err: ErrInvalidHeader, Claim: "zip", Detail: "compressed JWE not supported"}
+	}
+
+	encryptedKey, err := decoder(segments[1])
+	if err != nil {
+		return nil, nil, verificationError(ErrMalformedToken, "unable to decode encrypted key")
+	}
+
+	iv, err := decoder(segments[2])
+	if err != nil {
+		return nil, nil, verificationError(ErrMalformedToken, "unable to decode initialization vector")
+	}
+
+	ciphertext, err := decoder(segments[3])
+	if err != nil {
+		return nil, nil, verificationError(ErrMalformedToken, "unable to decode ciphertext")
+	}
+
+	tag, err := decoder(segments[4])
+	if err != nil {
+		return nil, nil, verificationError(ErrMalformedToken, "unable to decode authentication tag")
+	}
+
+	cekSize, err := contentKeySize(jweHeader.Enc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the protected header is authenticated as additional data
+	aad := []byte(header)
+
+	// each key that was tried reports why it failed
+	errs := []error{}
+	for i, key := range keys {
+		if jweHeader.Kid != "" && key.Kid != "" && key.Kid != jweHeader.Kid {
+			continue
+		}
+
+		plaintext, err := decryptWithKey(&jweHeader, key.Key, encryptedKey, cekSize, iv, ciphertext, tag, aad)
+		if err != nil {
+			name := key.Kid
+			if name == "" {
+				name = fmt.Sprint(i)
+			}
+
+			errs = append(errs, fmt.Errorf("key %s: %w", name, asVerificationError(err, ErrDecryptionFailed)))
+			continue
+		}
+
+		return plaintext, &jweHeader, nil
+	}
+
+	if len(errs) == 0 {
+		return nil, nil, &VerificationError{Err: ErrUnknownKid, Claim: "kid", Detail: "no decryption key " + jweHeader.Kid}
+	}
+
+	return nil, nil, joinErrors(errs...)
+}
+
+func decryptWithKey(header *JweHeader, key interface{}, encryptedKey []byte, cekSize int, iv []byte, ciphertext []byte, tag []byte, aad []byte) ([]byte, error) {
+	cek, err := decryptContentKey(header, key, encryptedKey, cekSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptContent(header.Enc, cek, iv, ciphertext, tag, aad)
+}
+
+// DecryptNested returns the inner JWS of a nested JWT (a JWS inside a JWE)
+func DecryptNested(token string, keys []DecryptionKey) (string, error) {
+	plaintext, header, err := DecryptCompact(token, keys)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.EqualFold(header.Cty, "JWT") {
+		return "", &VerificationError{Err: ErrInvalidHeader, Claim: "cty", Detail: "encrypted content is not a JWT"}
+	}
+
+	return string(plaintext), nil
+}
+
+// ParseDecryptionKeys reads the PEM encoded private keys (PKCS #8,
+// PKCS #1 or SEC 1) used to decrypt JWEs
+func ParseDecryptionKeys(pemData []byte) ([]DecryptionKey, error) {
+	keys := []DecryptionKey{}
+
+	for {
+		var block *pem.Block
+		block, pemData = pem.Decode(pemData)
+		if block == nil {
+			break
+		}
+
+		key, err := parsePrivateKey(block)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, DecryptionKey{Kid: block.Headers["kid"], Key: key})
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no private keys found")
+	}
+
+	return keys, nil
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.PrivateKey, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block: %s", block.Type)
+	}
+}
+
+func contentKeySize(enc string) (int, error) {
+	switch enc {
+	case "A128GCM":
+		return 16, nil
+	case "A256GCM":
+		return 32, nil
+	case "A128CBC-HS256":
+		return 32, nil
+	default:
+		return 0, &VerificationError{Err: ErrAlgorithmNotAllowed, Claim: "enc", Detail: enc}
+	}
+}
+
+func decryptContentKey(header *JweHeader, key interface{}, encryptedKey []byte, cekSize int) ([]byte, error) {
+	switch header.Alg {
+	case "RSA-OAEP-256":
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("not rsa private key")
+		}
+
+		cek, err := rsa.DecryptOAEP(sha256.New(), nil, rsaKey, encryptedKey, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(cek) != cekSize {
+			return nil, errors.New("invalid content encryption key length")
+		}
+
+		return cek, nil
+	case "ECDH-ES":
+		ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("not ecdsa private key")
+		}
+
+		if len(encryptedKey) != 0 {
+			return nil, errors.New("unexpected encrypted key")
+		}
+
+		return deriveEcdhEsKey(header, ecdsaKey, cekSize)
+	case "dir":
+		cek, ok := key.([]byte)
+		if !ok {
+			return nil, errors.New("not symmetric key")
+		}
+
+		if len(encryptedKey) != 0 {
+			return nil, errors.New("unexpected encrypted key")
+		}
+
+		if len(cek) != cekSize {
+			return nil, errors.New("invalid content encryption key length")
+		}
+
+		return cek, nil
+	default:
+		return nil, &VerificationError{Err: ErrAlgorithmNotAllowed, Claim: "alg", Detail: header.Alg}
+	}
+}
+
+// deriveEcdhEsKey computes the ECDH-ES direct key agreement of RFC 7518
+// section 4.6, using the ephemeral public key published in the header
+func deriveEcdhEsKey(header *JweHeader, key *ecdsa.PrivateKey, cekSize int) ([]byte, error) {
+	if header.Epk == nil || header.Epk.Kty != "EC" {
+		return nil, errors.New("missing ephemeral public key")
+	}
+
+	epk, err := publicKeyFromCoordinates(header.Epk.Crv, header.Epk.X, header.Epk.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	if epk.Curve.Params().Name != key.Curve.Params().Name {
+		return nil, errors.New("ephemeral key curve does not match")
+	}
+
+	x, _ := key.Curve.ScalarMult(epk.X, epk.Y, key.D.Bytes())
+	if x.Sign() == 0 {
+		return nil, errors.New("invalid shared secret")
+	}
+
+	size := (key.Curve.Params().BitSize + 7) / 8
+	z := x.FillBytes(make([]byte, size))
+
+	decoder := base64.RawURLEncoding.DecodeString
+
+	apu, err := decoder(header.Apu)
+	if err != nil {
+		return nil, errors.New("unable to decode apu")
+	}
+
+	apv, err := decoder(header.Apv)
+	if err != nil {
+		return nil, errors.New("unable to decode apv")
+	}
+
+	return concatKdf(z, []byte(header.Enc), apu, apv, cekSize), nil
+}
+
+// concatKdf is the Concat KDF of NIST SP 800-56A with SHA-256
+func concatKdf(z []byte, algorithmId []byte, apu []byte, apv []byte, keySize int) []byte {
+	lengthPrefixed := func(data []byte) []byte {
+		buffer := make([]byte, 4, 4+len(data))
+		binary.BigEndian.PutUint32(buffer, uint32(len(data)))
+		return append(buffer, data...)
+	}
+
+	var otherInfo []byte
+	otherInfo = append(otherInfo, lengthPrefixed(algorithmId)...)
+	otherInfo = append(otherInfo, lengthPrefixed(apu)...)
+	otherInfo = append(otherInfo, lengthPrefixed(apv)...)
+
+	suppPubInfo := make([]byte, 4)
+	binary.BigEndian.PutUint32(suppPubInfo, uint32(keySize*8))
+	otherInfo = append(otherInfo, suppPubInfo...)
+
+	var derived []byte
+	for counter := uint32(1); len(derived) < keySize; counter++ {
+		hasher := sha256.New()
+		binary.Write(hasher, binary.BigEndian, counter)
+		hasher.Write(z)
+		hasher.Write(otherInfo)
+		derived = hasher.Sum(derived)
+	}
+
+	return derived[:keySize]
+}
+
+func decryptContent(enc string, cek []byte, iv []byte, ciphertext []byte, tag []byte, aad []byte) ([]byte, error) {
+	switch enc {
+	case "A128GCM", "A256GCM":
+		block, err := aes.NewCipher(cek)
+		if err != nil {
+			return nil, err
+		}
+
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(iv) != gcm.NonceSize() || len(tag) != gcm.Overhead() {
+			return nil, errors.New("invalid initialization vector or tag length")
+		}
+
+		sealed := append(append([]byte{}, ciphertext...), tag...)
+
+		return gcm.Open(nil, iv, sealed, aad)
+	case "A128CBC-HS256":
+		return decryptAesCbcHmac(cek, iv, ciphertext, tag, aad)
+	default:
+		return nil, &VerificationError{Err: ErrAlgorithmNotAllowed, Claim: "enc", Detail: enc}
+	}
+}
+
+// decryptAesCbcHmac implements AES_128_CBC_HMAC_SHA_256 of RFC 7518
+// section 5.2, the tag is checked before anything is decrypted
+func decryptAesCbcHmac(cek []byte, iv []byte, ciphertext []byte, tag []byte, aad []byte) ([]byte, error) {
+	macKey := cek[:16]
+	encKey := cek[16:]
+
+	al := make([]byte, 8)
+	binary.BigEndian.PutUint64(al, uint64(len(aad))*8)
+
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(aad)
+	mac.Write(iv)
+	mac.Write(ciphertext)
+	mac.Write(al)
+
+	if !hmac.Equal(mac.Sum(nil)[:16], tag) {
+		return nil, errors.New("invalid authentication tag")
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid ciphertext length")
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, errors.New("invalid padding")
+	}
+
+	for _, b := range plaintext[len(plaintext)-padding:] {
+		if subtle.ConstantTimeByteEq(b, byte(padding)) != 1 {
+			return nil, errors.New("invalid padding")
+		}
+	}
+
+	return plaintext[:len(plaintext)-padding], nil
+}
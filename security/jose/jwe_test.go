@@ -0,0 +1,258 @@
+package jose
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestConcatKdf(t *testing.T) {
+	// RFC 7518 appendix C
+	z := []byte{158, 86, 217, 29, 129, 113, 53, 211, 114, 131, 66, 131, 191, 132, 38, 156,
+		251, 49, 110, 163, 218, 128, 106, 72, 246, 218, 167, 121, 140, 254, 144, 196}
+	want := "VqqN6vgjbSBcIijNcacQGg"
+
+	derived := concatKdf(z, []byte("A128GCM"), []byte("Alice"), []byte("Bob"), 16)
+	encoded := base64.RawURLEncoding.EncodeToString(derived)
+	if encoded != want {
+		t.Fatalf(`concatKdf() = %q, want match for %q`, encoded, want)
+	}
+}
+
+func TestDecryptCompactRsaOaep(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("inner.jws.token")
+
+	for _, enc := range []string{"A128GCM", "A256GCM", "A128CBC-HS256"} {
+		t.Run(enc, func(t *testing.T) {
+			size, _ := contentKeySize(enc)
+			cek := randomBytes(t, size)
+			encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &key.PublicKey, cek, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			header := JweHeader{Alg: "RSA-OAEP-256", Enc: enc, Kid: "enc1", Cty: "JWT"}
+			token := encryptCompact(t, header, encryptedKey, cek, plaintext)
+
+			keys := []DecryptionKey{{Kid: "enc1", Key: key}}
+			decrypted, _, err := DecryptCompact(token, keys)
+			if err != nil || !bytes.Equal(decrypted, plaintext) {
+				t.Fatalf(`DecryptCompact(%s) = %q, %v, want match for %q, nil`, enc, decrypted, err, plaintext)
+			}
+
+			segments := strings.Split(token, ".")
+			tag, _ := base64.RawURLEncoding.DecodeString(segments[4])
+			tag[0] ^= 1
+			segments[4] = base64.RawURLEncoding.EncodeToString(tag)
+
+			_, _, err = DecryptCompact(strings.Join(segments, "."), keys)
+			if err == nil {
+				t.Fatalf(`DecryptCompact(%s) = _, _, nil, want error for tampered tag`, enc)
+			}
+		})
+	}
+}
+
+func TestDecryptCompactEcdhEs(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ephemeral, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoder := base64.RawURLEncoding.EncodeToString
+	header := JweHeader{
+		Alg: "ECDH-ES",
+		Enc: "A256GCM",
+		Cty: "JWT",
+		Apu: encoder([]byte("Alice")),
+		Apv: encoder([]byte("Bob")),
+		Epk: &Jwk{
+			Kty: "EC",
+			Crv: "P-256",
+			X:   encoder(ephemeral.X.FillBytes(make([]byte, 32))),
+			Y:   encoder(ephemeral.Y.FillBytes(make([]byte, 32))),
+		},
+	}
+
+	x, _ := elliptic.P256().ScalarMult(key.X, key.Y, ephemeral.D.Bytes())
+	cek := concatKdf(x.FillBytes(make([]byte, 32)), []byte("A256GCM"), []byte("Alice"), []byte("Bob"), 32)
+
+	plaintext := []byte("inner.jws.token")
+	token := encryptCompact(t, header, nil, cek, plaintext)
+
+	inner, err := DecryptNested(token, []DecryptionKey{{Key: key}})
+	if err != nil || inner != string(plaintext) {
+		t.Fatalf(`DecryptNested() = %q, %v, want match for %q, nil`, inner, err, plaintext)
+	}
+}
+
+func TestDecryptCompactDir(t *testing.T) {
+	cek := randomBytes(t, 32)
+	plaintext := []byte("inner.jws.token")
+
+	header := JweHeader{Alg: "dir", Enc: "A128CBC-HS256"}
+	token := encryptCompact(t, header, nil, cek, plaintext)
+
+	decrypted, _, err := DecryptCompact(token, []DecryptionKey{{Key: cek}})
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf(`DecryptCompact(dir) = %q, %v, want match for %q, nil`, decrypted, err, plaintext)
+	}
+
+	_, _, err = DecryptCompact(token, []DecryptionKey{{Key: randomBytes(t, 32)}})
+	if err == nil {
+		t.Fatalf(`DecryptCompact(dir) = _, _, nil, want error for wrong key`)
+	}
+
+	_, err = DecryptNested(token, []DecryptionKey{{Key: cek}})
+	if err == nil {
+		t.Fatalf(`DecryptNested(dir) = _, nil, want error for missing cty`)
+	}
+}
+
+func TestDecryptCompactErrors(t *testing.T) {
+	cek := randomBytes(t, 32)
+	plaintext := []byte("inner.jws.token")
+
+	token := func(header JweHeader) string {
+		return encryptCompact(t, header, nil, cek, plaintext)
+	}
+
+	critical := func(header JweHeader, crit string) string {
+		encoded := token(header)
+		segments := strings.Split(encoded, ".")
+
+		members := map[string]interface{}{}
+		decoded, _ := base64.RawURLEncoding.DecodeString(segments[0])
+		json.Unmarshal(decoded, &members)
+		members["crit"] = []string{crit}
+		members[crit] = true
+		data, _ := json.Marshal(members)
+
+		segments[0] = base64.RawURLEncoding.EncodeToString(data)
+		return strings.Join(segments, ".")
+	}
+
+	dir := JweHeader{Alg: "dir", Enc: "A128CBC-HS256", Kid: "enc1"}
+
+	testCases := []struct {
+		name  string
+		token string
+		keys  []DecryptionKey
+		want  error
+	}{
+		{"not compact", "a.b.c", []DecryptionKey{{Key: cek}}, ErrMalformedToken},
+		{"unknown critical", critical(dir, "exp"), []DecryptionKey{{Key: cek}}, ErrInvalidHeader},
+		{"unsupported enc", token(JweHeader{Alg: "dir", Enc: "A192GCM"}), []DecryptionKey{{Key: cek}}, ErrAlgorithmNotAllowed},
+		{"unsupported alg", token(JweHeader{Alg: "RSA1_5", Enc: "A128CBC-HS256"}), []DecryptionKey{{Key: cek}}, ErrAlgorithmNotAllowed},
+		{"no key for kid", token(dir), []DecryptionKey{{Kid: "enc2", Key: cek}}, ErrUnknownKid},
+		{"wrong key", token(dir), []DecryptionKey{{Key: randomBytes(t, 32)}}, ErrDecryptionFailed},
+		{"wrong key type", token(dir), []DecryptionKey{{Key: "secret"}, {Key: randomBytes(t, 32)}}, ErrDecryptionFailed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := DecryptCompact(tc.token, tc.keys)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf(`DecryptCompact() = %v, want match for %v`, err, tc.want)
+			}
+		})
+	}
+
+	_, _, err := DecryptCompact(token(JweHeader{Alg: "dir", Enc: "A128CBC-HS256"}), []DecryptionKey{{Kid: "a", Key: "secret"}, {Kid: "b", Key: randomBytes(t, 32)}})
+	if err == nil || !strings.Contains(err.Error(), "key a") || !strings.Contains(err.Error(), "key b") {
+		t.Fatalf(`DecryptCompact() = %v, want match for the errors of keys a and b`, err)
+	}
+}
+
+func TestParseDecryptionKeys(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := &pem.Block{Type: "PRIVATE KEY", Headers: map[string]string{"kid": "enc1"}, Bytes: der}
+	keys, err := ParseDecryptionKeys(pem.EncodeToMemory(block))
+	if err != nil || len(keys) != 1 || keys[0].Kid != "enc1" {
+		t.Fatalf(`ParseDecryptionKeys() = %+v, %v, want match for 1 key, nil`, keys, err)
+	}
+
+	_, err = ParseDecryptionKeys([]byte("not pem"))
+	if err == nil {
+		t.Fatalf(`ParseDecryptionKeys("not pem") = _, nil, want error`)
+	}
+}
+
+func encryptCompact(t *testing.T, header JweHeader, encryptedKey []byte, cek []byte, plaintext []byte) string {
+	encoder := base64.RawURLEncoding.EncodeToString
+
+	headerJson, _ := json.Marshal(header)
+	encodedHeader := encoder(headerJson)
+	aad := []byte(encodedHeader)
+
+	var iv, ciphertext, tag []byte
+	switch header.Enc {
+	case "A128GCM", "A256GCM":
+		block, _ := aes.NewCipher(cek)
+		gcm, _ := cipher.NewGCM(block)
+		iv = randomBytes(t, gcm.NonceSize())
+		sealed := gcm.Seal(nil, iv, plaintext, aad)
+		ciphertext = sealed[:len(sealed)-gcm.Overhead()]
+		tag = sealed[len(sealed)-gcm.Overhead():]
+	case "A128CBC-HS256":
+		block, _ := aes.NewCipher(cek[16:])
+		iv = randomBytes(t, aes.BlockSize)
+		padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+		padded := append(append([]byte{}, plaintext...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+		ciphertext = make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+		al := make([]byte, 8)
+		binary.BigEndian.PutUint64(al, uint64(len(aad))*8)
+		mac := hmac.New(sha256.New, cek[:16])
+		mac.Write(aad)
+		mac.Write(iv)
+		mac.Write(ciphertext)
+		mac.Write(al)
+		tag = mac.Sum(nil)[:16]
+	}
+
+	return encodedHeader + "." + encoder(encryptedKey) + "." + encoder(iv) + "." + encoder(ciphertext) + "." + encoder(tag)
+}
+
+func randomBytes(t *testing.T, size int) []byte {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
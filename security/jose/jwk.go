@@ -1,71 +1,298 @@
 package jose
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
 	"net/http"
 	"time"
 
-	"dahbura.me/api/util/cache"
 	httppkg "dahbura.me/api/util/http"
 )
 
-func fetchEncodedDer(jwksUrl string, kid string) (string, error) {
-	memoryCache := cache.GetMemoryCache()
+func fetchJwk(jwksUrl string, kid string) (*Jwk, error) {
+	return GetJwksCache().Key(jwksUrl, kid)
+}
+
+func readJwkSet(jwksUrl string) (*JwkSet, error) {
+	jwks, _, err := fetchJwkSet(jwksUrl)
 
-	encodedDer, ok := memoryCache.Get(kid)
-	if ok {
-		return encodedDer.(string), nil
+	return jwks, err
+}
+
+func fetchJwkSet(jwksUrl string) (*JwkSet, time.Duration, error) {
+	req, err := http.NewRequest(http.MethodGet, jwksUrl, nil)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	jwks, err := readJwkSet(jwksUrl)
+	httpClient := httppkg.GetHttpClient()
+
+	res, err := httpClient.Do(req)
 	if err != nil {
-		return "", err
+		return nil, 0, err
 	}
 
-	for _, key := range jwks.Keys {
-		if key.Kid == kid {
-			encodedDer = key.X5C[0]
-			break
-		}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("unexpected JWKS response status: %d", res.StatusCode)
 	}
 
-	item := cache.Item{
-		Key:   kid,
-		Value: encodedDer,
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	now := time.Now()
-	itemPolicy := cache.ItemPolicy{
-		AbsoluteExp: now.Add(time.Second * 10),
+	jwks, err := ParseJwkSet(body)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	memoryCache.Set(item, itemPolicy)
+	maxAge := parseMaxAge(res.Header.Get("Cache-Control"))
 
-	return encodedDer.(string), nil
+	return jwks, maxAge, nil
 }
 
-func readJwkSet(jwksUrl string) (*JwkSet, error) {
-	req, err := http.NewRequest(http.MethodGet, jwksUrl, nil)
-	if err != nil {
+// ParseJwkSet reads a JWKS document, members it does not know (key_ops,
+// x5t#S256, vendor extensions) are ignored
+func ParseJwkSet(data []byte) (*JwkSet, error) {
+	var jwks JwkSet
+	if err := json.Unmarshal(data, &jwks); err != nil {
 		return nil, err
 	}
 
-	httpClient := httppkg.GetHttpClient()
+	if jwks.Keys == nil {
+		return nil, errors.New("jwks keys required")
+	}
 
-	res, err := httpClient.Do(req)
-	if err != nil {
+	return &jwks, nil
+}
+
+// ParseJwk reads a single JWK, unknown members are ignored
+func ParseJwk(data []byte) (*Jwk, error) {
+	var jwk Jwk
+	if err := json.Unmarshal(data, &jwk); err != nil {
 		return nil, err
 	}
 
-	defer res.Body.Close()
+	if jwk.Kty == "" {
+		return nil, errors.New("jwk kty required")
+	}
+
+	return &jwk, nil
+}
 
-	decoder := json.NewDecoder(res.Body)
-	decoder.DisallowUnknownFields()
+// NewJwk returns the JWK representation of an RSA, EC or Ed25519 public
+// or private key, private keys include their private members
+func NewJwk(key interface{}) (*Jwk, error) {
+	encoder := base64.RawURLEncoding.EncodeToString
 
-	var jwks JwkSet
-	if err := decoder.Decode(&jwks); err != nil {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		e := new(big.Int).SetInt64(int64(k.E))
+		return &Jwk{Kty: "RSA", N: encoder(k.N.Bytes()), E: encoder(e.Bytes())}, nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return &Jwk{
+			Kty: "EC",
+			Crv: k.Curve.Params().Name,
+			X:   encoder(k.X.FillBytes(make([]byte, size))),
+			Y:   encoder(k.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return &Jwk{Kty: "OKP", Crv: "Ed25519", X: encoder(k)}, nil
+	case *rsa.PrivateKey:
+		if len(k.Primes) != 2 {
+			return nil, errors.New("multi-prime RSA keys not supported")
+		}
+
+		k.Precompute()
+		jwk, _ := NewJwk(&k.PublicKey)
+		jwk.D = encoder(k.D.Bytes())
+		jwk.P = encoder(k.Primes[0].Bytes())
+		jwk.Q = encoder(k.Primes[1].Bytes())
+		jwk.DP = encoder(k.Precomputed.Dp.Bytes())
+		jwk.DQ = encoder(k.Precomputed.Dq.Bytes())
+		jwk.QI = encoder(k.Precomputed.Qinv.Bytes())
+		return jwk, nil
+	case *ecdsa.PrivateKey:
+		jwk, err := NewJwk(&k.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.D = encoder(k.D.FillBytes(make([]byte, size)))
+		return jwk, nil
+	case ed25519.PrivateKey:
+		jwk, _ := NewJwk(k.Public())
+		jwk.D = encoder(k.Seed())
+		return jwk, nil
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+}
+
+// IsPrivate reports whether the JWK carries private key members
+func (jwk *Jwk) IsPrivate() bool {
+	return jwk.D != ""
+}
+
+// Public returns a copy of the JWK without its private members
+func (jwk *Jwk) Public() *Jwk {
+	public := *jwk
+	public.D = ""
+	public.P = ""
+	public.Q = ""
+	public.DP = ""
+	public.DQ = ""
+	public.QI = ""
+
+	return &public
+}
+
+// PublicKey returns the *rsa.PublicKey, *ecdsa.PublicKey or
+// ed25519.PublicKey the JWK describes
+func (jwk *Jwk) PublicKey() (crypto.PublicKey, error) {
+	return publicKeyFromJwk(jwk)
+}
+
+// PrivateKey returns the *rsa.PrivateKey, *ecdsa.PrivateKey or
+// ed25519.PrivateKey the JWK describes
+func (jwk *Jwk) PrivateKey() (crypto.Signer, error) {
+	if !jwk.IsPrivate() {
+		return nil, errors.New("jwk has no private key")
+	}
+
+	decoder := base64.RawURLEncoding.DecodeString
+
+	d, err := decoder(jwk.D)
+	if err != nil {
 		return nil, err
 	}
 
-	return &jwks, nil
+	switch jwk.Kty {
+	case "RSA":
+		public, err := publicKeyFromExponentAndModulus(jwk.E, jwk.N)
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := decoder(jwk.P)
+		if err != nil {
+			return nil, err
+		}
+
+		q, err := decoder(jwk.Q)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &rsa.PrivateKey{
+			PublicKey: *public,
+			D:         new(big.Int).SetBytes(d),
+			Primes:    []*big.Int{new(big.Int).SetBytes(p), new(big.Int).SetBytes(q)},
+		}
+
+		if err := key.Validate(); err != nil {
+			return nil, err
+		}
+
+		key.Precompute()
+		return key, nil
+	case "EC":
+		public, err := publicKeyFromCoordinates(jwk.Crv, jwk.X, jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &ecdsa.PrivateKey{PublicKey: *public, D: new(big.Int).SetBytes(d)}
+		x, y := public.Curve.ScalarBaseMult(d)
+		if x.Cmp(public.X) != 0 || y.Cmp(public.Y) != 0 {
+			return nil, errors.New("private key does not match public key")
+		}
+
+		return key, nil
+	case "OKP":
+		public, err := publicKeyFromOctetKeyPair(jwk.Crv, jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(d) != ed25519.SeedSize {
+			return nil, errors.New("invalid private key length")
+		}
+
+		key := ed25519.NewKeyFromSeed(d)
+		if !public.Equal(key.Public()) {
+			return nil, errors.New("private key does not match public key")
+		}
+
+		return key, nil
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+}
+
+// Thumbprint computes the RFC 7638 JWK thumbprint, the base64url hash of
+// the required public members, suitable as a kid
+func (jwk *Jwk) Thumbprint(hash crypto.Hash) (string, error) {
+	var members map[string]string
+
+	switch jwk.Kty {
+	case "RSA":
+		members = map[string]string{"e": jwk.E, "kty": jwk.Kty, "n": jwk.N}
+	case "EC":
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X, "y": jwk.Y}
+	case "OKP":
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X}
+	default:
+		return "", errors.New("unsupported key type")
+	}
+
+	for _, value := range members {
+		if value == "" {
+			return "", errors.New("jwk is missing required members")
+		}
+	}
+
+	if !hash.Available() {
+		return "", errors.New("unavailable hash function")
+	}
+
+	// encoding/json writes map keys in lexicographic order without
+	// whitespace, the canonical form RFC 7638 requires
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	hasher := hash.New()
+	hasher.Write(data)
+
+	return base64.RawURLEncoding.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Public returns a copy of the set with private members removed, ready
+// to be published
+func (jwks *JwkSet) Public() *JwkSet {
+	public := JwkSet{Keys: make([]Jwk, 0, len(jwks.Keys))}
+	for i := range jwks.Keys {
+		public.Keys = append(public.Keys, *jwks.Keys[i].Public())
+	}
+
+	return &public
+}
+
+// Key returns the key with the kid, nil when the set does not contain it
+func (jwks *JwkSet) Key(kid string) *Jwk {
+	return findJwk(jwks, kid)
 }
@@ -1,9 +1,17 @@
 package jose
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -70,3 +78,94 @@ func TestReadJwkSet(t *testing.T) {
 		})
 	}
 }
+
+func TestParseJwkSetLenient(t *testing.T) {
+	data := []byte(`{
+		"keys": [
+			{
+				"kty": "EC",
+				"crv": "P-256",
+				"x": "f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU",
+				"y": "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0",
+				"kid": "ec1",
+				"key_ops": ["verify"],
+				"x5t#S256": "...",
+				"ext": true
+			}
+		]
+	}`)
+
+	jwks, err := ParseJwkSet(data)
+	if err != nil || jwks.Key("ec1") == nil || jwks.Key("ec1").X5TS256 != "..." {
+		t.Fatalf(`ParseJwkSet() = %+v, %v, want match for key ec1, nil`, jwks, err)
+	}
+
+	_, err = ParseJwkSet([]byte(JwkSetInvalidJson))
+	if err == nil {
+		t.Fatalf(`ParseJwkSet(no keys) = _, nil, want error`)
+	}
+}
+
+func TestJwkThumbprint(t *testing.T) {
+	// RFC 7638 section 3.1
+	jwk := Jwk{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+		Alg: "RS256",
+		Kid: "2011-04-29",
+	}
+
+	want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+	got, err := jwk.Thumbprint(crypto.SHA256)
+	if got != want || err != nil {
+		t.Fatalf(`Thumbprint() = %q, %v, want match for %q, nil`, got, err, want)
+	}
+
+	_, err = (&Jwk{Kty: "EC", Crv: "P-256"}).Thumbprint(crypto.SHA256)
+	if err == nil {
+		t.Fatalf(`Thumbprint(missing members) = _, nil, want error`)
+	}
+}
+
+func TestJwkKeyConversion(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	for _, key := range []crypto.Signer{rsaKey, ecKey, edKey} {
+		jwk, err := NewJwk(key)
+		if err != nil || !jwk.IsPrivate() {
+			t.Fatalf(`NewJwk(%T) = %+v, %v, want match for private jwk, nil`, key, jwk, err)
+		}
+
+		privateKey, err := jwk.PrivateKey()
+		if err != nil || !privateKey.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()) {
+			t.Fatalf(`PrivateKey(%T) = %v, want match for original key, nil`, key, err)
+		}
+
+		public := jwk.Public()
+		if public.IsPrivate() || !jwk.IsPrivate() {
+			t.Fatalf(`Public(%T) = %+v, want match for public jwk`, key, public)
+		}
+
+		publicKey, err := public.PublicKey()
+		if err != nil || !publicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()) {
+			t.Fatalf(`PublicKey(%T) = %v, want match for original key, nil`, key, err)
+		}
+
+		_, err = public.PrivateKey()
+		if err == nil {
+			t.Fatalf(`PrivateKey(public %T) = _, nil, want error`, key)
+		}
+	}
+
+	jwks := JwkSet{Keys: []Jwk{}}
+	jwk, _ := NewJwk(ecKey)
+	jwks.Keys = append(jwks.Keys, *jwk)
+
+	data, _ := json.Marshal(jwks.Public())
+	if strings.Contains(string(data), `"d"`) {
+		t.Fatalf(`json.Marshal(Public()) = %s, want no private members`, data)
+	}
+}
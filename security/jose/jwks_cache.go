@@ -0,0 +1,232 @@
+package jose
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dahbura.me/api/config"
+)
+
+var (
+	jwksCache     *JwksCache
+	jwksCacheOnce sync.Once
+)
+
+type JwksCacheOpts struct {
+	// MaxAge is used when the JWKS response has no Cache-Control max-age
+	MaxAge time.Duration
+	// MinMaxAge and MaxMaxAge bound the max-age advertised by the issuer
+	MinMaxAge time.Duration
+	MaxMaxAge time.Duration
+	// RefreshAhead starts a background refresh this long before expiry
+	RefreshAhead time.Duration
+	// MinRefetch is the minimum interval between fetches of the same JWKS
+	// triggered by an unknown kid
+	MinRefetch time.Duration
+	// StaleIfError serves the last good key set for this long after
+	// expiry while the issuer is unreachable
+	StaleIfError time.Duration
+}
+
+// JwksCache caches whole key sets per JWKS URL so rotated keys are picked
+// up without refetching the set on every request
+type JwksCache struct {
+	opts       JwksCacheOpts
+	entries    map[string]*jwksEntry
+	entriesMtx sync.Mutex
+	fetch      func(string) (*JwkSet, time.Duration, error)
+	now        func() time.Time
+}
+
+type jwksEntry struct {
+	mtx        sync.Mutex
+	jwks       *JwkSet
+	expiresAt  time.Time
+	lastFetch  time.Time
+	refreshing bool
+}
+
+func GetJwksCache() *JwksCache {
+	jwksCacheOnce.Do(initJwksCache)
+
+	return jwksCache
+}
+
+func initJwksCache() {
+	jwksCache = NewJwksCache(JwksCacheOpts{
+		MaxAge:       config.DefaultJwksMaxAge,
+		MinMaxAge:    config.DefaultJwksMinMaxAge,
+		MaxMaxAge:    config.DefaultJwksMaxMaxAge,
+		RefreshAhead: config.DefaultJwksRefreshAhead,
+		MinRefetch:   config.DefaultJwksMinRefetch,
+		StaleIfError: config.DefaultJwksStaleIfError,
+	})
+}
+
+func NewJwksCache(opts JwksCacheOpts) *JwksCache {
+	jc := JwksCache{
+		opts:       opts,
+		entries:    map[string]*jwksEntry{},
+		entriesMtx: sync.Mutex{},
+		fetch:      fetchJwkSet,
+		now:        time.Now,
+	}
+
+	return &jc
+}
+
+// Key returns the key identified by kid from the JWKS at jwksUrl
+func (jc *JwksCache) Key(jwksUrl string, kid string) (*Jwk, error) {
+	entry := jc.entry(jwksUrl)
+
+	entry.mtx.Lock()
+	defer entry.mtx.Unlock()
+
+	now := jc.now()
+
+	if entry.jwks == nil || !now.Before(entry.expiresAt) {
+		err := verificationError(ErrKeyUnavailable, "jwks temporarily unavailable")
+		if jc.canRefetch(entry, now) {
+			err = jc.refresh(jwksUrl, entry, now)
+		}
+		if err != nil && !jc.isStaleUsable(entry, now) {
+			return nil, err
+		}
+	} else if now.After(entry.expiresAt.Add(-jc.opts.RefreshAhead)) && !entry.refreshing {
+		entry.refreshing = true
+		go jc.refreshInBackground(jwksUrl, entry)
+	}
+
+	jwk := findJwk(entry.jwks, kid)
+	if jwk != nil {
+		return jwk, nil
+	}
+
+	// an unknown kid may mean the issuer rotated its keys, but refetching
+	// is rate limited so unknown kids cannot be used to flood the issuer
+	if !jc.canRefetch(entry, now) {
+		return nil, ErrUnknownKid
+	}
+
+	err := jc.refresh(jwksUrl, entry, now)
+	if err != nil {
+		return nil, err
+	}
+
+	jwk = findJwk(entry.jwks, kid)
+	if jwk == nil {
+		return nil, ErrUnknownKid
+	}
+
+	return jwk, nil
+}
+
+func (jc *JwksCache) entry(jwksUrl string) *jwksEntry {
+	jc.entriesMtx.Lock()
+	defer jc.entriesMtx.Unlock()
+
+	entry, ok := jc.entries[jwksUrl]
+	if !ok {
+		entry = &jwksEntry{}
+		jc.entries[jwksUrl] = entry
+	}
+
+	return entry
+}
+
+func (jc *JwksCache) canRefetch(entry *jwksEntry, now time.Time) bool {
+	return entry.lastFetch.IsZero() || now.Sub(entry.lastFetch) >= jc.opts.MinRefetch
+}
+
+func (jc *JwksCache) isStaleUsable(entry *jwksEntry, now time.Time) bool {
+	return entry.jwks != nil && now.Before(entry.expiresAt.Add(jc.opts.StaleIfError))
+}
+
+// refresh fetches the key set, the entry lock must be held
+func (jc *JwksCache) refresh(jwksUrl string, entry *jwksEntry, now time.Time) error {
+	entry.lastFetch = now
+
+	jwks, maxAge, err := jc.fetch(jwksUrl)
+	if err != nil {
+		return err
+	}
+
+	entry.jwks = jwks
+	entry.expiresAt = now.Add(jc.maxAge(maxAge))
+
+	return nil
+}
+
+func (jc *JwksCache) refreshInBackground(jwksUrl string, entry *jwksEntry) {
+	jwks, maxAge, err := jc.fetch(jwksUrl)
+
+	entry.mtx.Lock()
+	defer entry.mtx.Unlock()
+
+	entry.refreshing = false
+
+	now := jc.now()
+	entry.lastFetch = now
+
+	if err != nil {
+		return
+	}
+
+	entry.jwks = jwks
+	entry.expiresAt = now.Add(jc.maxAge(maxAge))
+}
+
+func (jc *JwksCache) maxAge(maxAge time.Duration) time.Duration {
+	if maxAge <= 0 {
+		maxAge = jc.opts.MaxAge
+	}
+
+	if maxAge < jc.opts.MinMaxAge {
+		return jc.opts.MinMaxAge
+	}
+
+	if jc.opts.MaxMaxAge > 0 && maxAge > jc.opts.MaxMaxAge {
+		return jc.opts.MaxMaxAge
+	}
+
+	return maxAge
+}
+
+func findJwk(jwks *JwkSet, kid string) *Jwk {
+	if jwks == nil {
+		return nil
+	}
+
+	for i, key := range jwks.Keys {
+		if key.Kid == kid {
+			return &jwks.Keys[i]
+		}
+	}
+
+	return nil
+}
+
+// parseMaxAge returns the max-age directive of a Cache-Control header,
+// or 0 when it is absent or caching is not allowed
+func parseMaxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+
+		if directive == "no-cache" || directive == "no-store" {
+			return 0
+		}
+
+		if strings.HasPrefix(directive, "max-age=") {
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil || seconds < 0 {
+				return 0
+			}
+
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,191 @@
+package jose
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeJwksSource struct {
+	mtx     sync.Mutex
+	jwks    *JwkSet
+	maxAge  time.Duration
+	err     error
+	fetches int
+	fetched chan struct{}
+}
+
+func (f *fakeJwksSource) fetch(jwksUrl string) (*JwkSet, time.Duration, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.fetches++
+	if f.fetched != nil {
+		defer func() { f.fetched <- struct{}{} }()
+	}
+
+	if f.err != nil {
+		return nil, 0, f.err
+	}
+
+	return f.jwks, f.maxAge, nil
+}
+
+func (f *fakeJwksSource) count() int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return f.fetches
+}
+
+func newTestJwksCache(source *fakeJwksSource, now *time.Time) *JwksCache {
+	jc := NewJwksCache(JwksCacheOpts{
+		MaxAge:       time.Minute * 10,
+		MinMaxAge:    time.Minute,
+		MaxMaxAge:    time.Hour,
+		RefreshAhead: time.Minute,
+		MinRefetch:   time.Second * 30,
+		StaleIfError: time.Hour,
+	})
+	jc.fetch = source.fetch
+	jc.now = func() time.Time { return *now }
+
+	return jc
+}
+
+func TestJwksCacheKey(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	source := &fakeJwksSource{jwks: &JwkSet{Keys: []Jwk{{Kid: "kid1"}}}}
+	jc := newTestJwksCache(source, &now)
+
+	for i := 0; i < 3; i++ {
+		jwk, err := jc.Key("https://issuer/jwks", "kid1")
+		if err != nil || jwk.Kid != "kid1" {
+			t.Fatalf(`Key("kid1") = %v, %v, want match for kid1, nil`, jwk, err)
+		}
+	}
+
+	if source.count() != 1 {
+		t.Fatalf(`fetches = %d, want match for 1`, source.count())
+	}
+}
+
+func TestJwksCacheUnknownKidRateLimited(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	source := &fakeJwksSource{jwks: &JwkSet{Keys: []Jwk{{Kid: "kid1"}}}}
+	jc := newTestJwksCache(source, &now)
+
+	for i := 0; i < 5; i++ {
+		_, err := jc.Key("https://issuer/jwks", "unknown")
+		if err == nil {
+			t.Fatalf(`Key("unknown") = _, nil, want error`)
+		}
+	}
+
+	if source.count() != 1 {
+		t.Fatalf(`fetches = %d, want match for 1`, source.count())
+	}
+
+	// rotated key becomes available once the refetch interval elapsed
+	source.jwks = &JwkSet{Keys: []Jwk{{Kid: "kid1"}, {Kid: "kid2"}}}
+	now = now.Add(time.Second * 31)
+
+	jwk, err := jc.Key("https://issuer/jwks", "kid2")
+	if err != nil || jwk.Kid != "kid2" {
+		t.Fatalf(`Key("kid2") = %v, %v, want match for kid2, nil`, jwk, err)
+	}
+
+	if source.count() != 2 {
+		t.Fatalf(`fetches = %d, want match for 2`, source.count())
+	}
+}
+
+func TestJwksCacheMaxAge(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	source := &fakeJwksSource{
+		jwks:   &JwkSet{Keys: []Jwk{{Kid: "kid1"}}},
+		maxAge: time.Minute * 2,
+	}
+	jc := newTestJwksCache(source, &now)
+
+	jc.Key("https://issuer/jwks", "kid1")
+
+	now = now.Add(time.Minute*2 + time.Second)
+	jc.Key("https://issuer/jwks", "kid1")
+
+	if source.count() != 2 {
+		t.Fatalf(`fetches = %d, want match for 2`, source.count())
+	}
+}
+
+func TestJwksCacheStaleIfError(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	source := &fakeJwksSource{jwks: &JwkSet{Keys: []Jwk{{Kid: "kid1"}}}}
+	jc := newTestJwksCache(source, &now)
+
+	jc.Key("https://issuer/jwks", "kid1")
+
+	source.err = errors.New("unreachable")
+	now = now.Add(time.Minute * 11)
+
+	jwk, err := jc.Key("https://issuer/jwks", "kid1")
+	if err != nil || jwk.Kid != "kid1" {
+		t.Fatalf(`Key("kid1") = %v, %v, want stale kid1, nil`, jwk, err)
+	}
+
+	now = now.Add(time.Hour)
+
+	_, err = jc.Key("https://issuer/jwks", "kid1")
+	if err == nil {
+		t.Fatalf(`Key("kid1") = _, nil, want error once stale window elapsed`)
+	}
+}
+
+func TestJwksCacheBackgroundRefresh(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	source := &fakeJwksSource{jwks: &JwkSet{Keys: []Jwk{{Kid: "kid1"}}}}
+	jc := newTestJwksCache(source, &now)
+
+	jc.Key("https://issuer/jwks", "kid1")
+
+	source.fetched = make(chan struct{}, 1)
+	now = now.Add(time.Minute*9 + time.Second*30)
+
+	jwk, err := jc.Key("https://issuer/jwks", "kid1")
+	if err != nil || jwk.Kid != "kid1" {
+		t.Fatalf(`Key("kid1") = %v, %v, want match for kid1, nil`, jwk, err)
+	}
+
+	select {
+	case <-source.fetched:
+	case <-time.After(time.Second):
+		t.Fatalf(`background refresh did not fetch the key set`)
+	}
+
+	if source.count() != 2 {
+		t.Fatalf(`fetches = %d, want match for 2`, source.count())
+	}
+}
+
+func TestParseMaxAge(t *testing.T) {
+	testCases := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"max-age=300", time.Minute * 5},
+		{"public, max-age=60, stale-while-revalidate=30", time.Minute},
+		{"no-store", 0},
+		{"max-age=abc", 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.header, func(t *testing.T) {
+			maxAge := parseMaxAge(tc.header)
+			if maxAge != tc.want {
+				t.Fatalf(`parseMaxAge(%q) = %v, want match for %v`, tc.header, maxAge, tc.want)
+			}
+		})
+	}
+}
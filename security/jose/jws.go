@@ -2,97 +2,160 @@ package jose
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/big"
 	"net/url"
 	"strings"
 	"time"
-	"unicode/utf8"
 )
 
 // JSON Object Signing and Encryption (jose)
 
 type JoseHeader struct {
-	Alg string `json:"alg"`
-	Kid string `json:"kid"`
-	Typ string `json:"typ"`
+	Alg     string   `json:"alg"`
+	Kid     string   `json:"kid,omitempty"`
+	Typ     string   `json:"typ,omitempty"`
+	Cty     string   `json:"cty,omitempty"`
+	Jku     string   `json:"jku,omitempty"`
+	Jwk     *Jwk     `json:"jwk,omitempty"`
+	X5U     string   `json:"x5u,omitempty"`
+	X5C     []string `json:"x5c,omitempty"`
+	X5T     string   `json:"x5t,omitempty"`
+	X5TS256 string   `json:"x5t#S256,omitempty"`
+	B64     *bool    `json:"b64,omitempty"`
+	Crit    []string `json:"crit,omitempty"`
 }
 
 type Jwk struct {
-	Alg string   `json:"alg"`
-	Kty string   `json:"kty"`
-	Use string   `json:"use"`
-	N   string   `json:"n"`
-	E   string   `json:"e"`
-	Kid string   `json:"kid"`
-	X5T string   `json:"x5t"`
-	X5C []string `json:"x5c"`
+	Alg     string   `json:"alg,omitempty"`
+	Kty     string   `json:"kty"`
+	Use     string   `json:"use,omitempty"`
+	KeyOps  []string `json:"key_ops,omitempty"`
+	Crv     string   `json:"crv,omitempty"`
+	N       string   `json:"n,omitempty"`
+	E       string   `json:"e,omitempty"`
+	X       string   `json:"x,omitempty"`
+	Y       string   `json:"y,omitempty"`
+	Kid     string   `json:"kid,omitempty"`
+	X5T     string   `json:"x5t,omitempty"`
+	X5TS256 string   `json:"x5t#S256,omitempty"`
+	X5C     []string `json:"x5c,omitempty"`
+
+	// private key members, never part of a published set
+	D  string `json:"d,omitempty"`
+	P  string `json:"p,omitempty"`
+	Q  string `json:"q,omitempty"`
+	DP string `json:"dp,omitempty"`
+	DQ string `json:"dq,omitempty"`
+	QI string `json:"qi,omitempty"`
 }
 
 type JwkSet struct {
 	Keys []Jwk `json:"keys"`
 }
 
-type Jwt struct {
-	Iss string      `json:"iss"`
-	Sub string      `json:"sub"`
-	Aud interface{} `json:"aud"`
-	Exp int64       `json:"exp"`
-	Iat int64       `json:"iat"`
-}
+// VerifyOpts describes how a token is validated after its signature
+// has been verified
+type VerifyOpts struct {
+	Issuer   string
+	Audience string
+
+	// JwksUrl locates the issuer keys, the conventional
+	// {issuer}/.well-known/jwks.json is used when empty
+	JwksUrl string
+
+	// Keys resolves the issuer keys instead of JwksUrl, e.g. from a file,
+	// inline or pinned keys that never go to the network
+	Keys KeySource
+
+	// Leeway is the clock skew tolerated when comparing exp, nbf and iat
+	Leeway time.Duration
+
+	// MaxAge rejects tokens issued longer ago than this (0 disables)
+	MaxAge time.Duration
+
+	// RequiredClaims must be present in the payload (e.g. "sub", "azp")
+	RequiredClaims []string
 
-// ExpirationTime returns the local expiration time on or after
-// which the JWT MUST NOT be accepted
-func (jwt *Jwt) ExpirationTime() time.Time {
-	return time.Unix(jwt.Exp, 0)
+	// Algorithms allowed for the issuer, DefaultAlgorithms when empty
+	Algorithms []string
+
+	// AccessTokenProfile enforces the RFC 9068 JWT access token profile
+	AccessTokenProfile bool
+
+	// HeaderKeys allows jku/x5u/jwk/x5c header keys, refused when nil
+	HeaderKeys *HeaderKeyPolicy
+
+	// Certificates validates the x5c chain and validity of signing keys
+	Certificates *CertificatePolicy
+
+	// Secrets verify HS256/HS384/HS512 tokens, an issuer with secrets
+	// accepts only HMAC and its keys are never looked up
+	Secrets []HmacSecret
 }
 
-// IssuedAt returns the local time at which the JWT was issued
-func (jwt *Jwt) IssuedAt() time.Time {
-	return time.Unix(jwt.Iat, 0)
+// IsCompactJws reports whether the token uses the three segment JWS
+// Compact Serialization format with a JSON protected header
+func IsCompactJws(token string) bool {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return false
+	}
+
+	decodedHeader, err := base64.RawURLEncoding.DecodeString(segments[0])
+	if err != nil {
+		return false
+	}
+
+	var header map[string]interface{}
+	return json.Unmarshal(decodedHeader, &header) == nil
 }
 
-// VerifyCompact returns the verified state of a JWT using the
+// VerifyCompact returns the verified claims of a JWT using the
 // JWS Compact Serialization format.
-func VerifyCompact(token string, issuer string, audience string) error {
+func VerifyCompact(token string, opts VerifyOpts) (*Claims, error) {
 	if len(token) == 0 {
-		return errors.New("missing token")
+		return nil, verificationError(ErrMalformedToken, "missing token")
 	}
 
 	segments := strings.Split(token, ".")
 	if len(segments) != 3 {
-		return errors.New("incompatible token detected (not JWS compact)")
+		return nil, verificationError(ErrMalformedToken, "not JWS compact")
 	}
 
-	_, err := url.ParseRequestURI(issuer)
+	jwksUrl, err := resolveJwksUrl(opts)
 	if err != nil {
-		return errors.New("improperyl formatted issuer")
+		return nil, err
 	}
 
-	jwksUrl := fmt.Sprintf("%s/.well-known/jwks.json", strings.TrimSuffix(issuer, "/"))
-
 	decoder := base64.RawURLEncoding.DecodeString
 
 	// JWS header
 
+	// no critical extensions are understood for JWTs
 	header := segments[0]
-	decodedHeader, err := decoder(header)
+	joseHeader, err := parseJoseHeader(header, nil)
 	if err != nil {
-		return errors.New("unable to decode token header")
+		return nil, err
 	}
 
-	if !utf8.Valid(decodedHeader) {
-		return errors.New("not a valid UTF-8 encoded sequence")
+	err = verifyHeader(joseHeader, opts)
+	if err != nil {
+		return nil, err
 	}
 
-	var joseHeader JoseHeader
-	json.Unmarshal(decodedHeader, &joseHeader)
-	if err != nil {
-		return errors.New("unable to parse token header")
+	// a JWT claims set has no cty, nested JWTs are only supported
+	// inside a JWE
+	if joseHeader.Cty != "" {
+		return nil, verificationError(ErrInvalidHeader, "unsupported content type: %s", joseHeader.Cty)
 	}
 
 	// JWS payload
@@ -100,13 +163,13 @@ func VerifyCompact(token string, issuer string, audience string) error {
 	payload := segments[1]
 	decodedPayload, err := decoder(payload)
 	if err != nil {
-		return errors.New("unable to decode token payload")
+		return nil, verificationError(ErrMalformedToken, "unable to decode token payload")
 	}
 
-	var jwt Jwt
-	json.Unmarshal(decodedPayload, &jwt)
+	var claims Claims
+	err = json.Unmarshal(decodedPayload, &claims)
 	if err != nil {
-		return errors.New("unable to parse token payload")
+		return nil, verificationError(ErrMalformedToken, "unable to parse token payload")
 	}
 
 	// JWS signature
@@ -114,45 +177,137 @@ func VerifyCompact(token string, issuer string, audience string) error {
 	signature := segments[2]
 	decodedSignature, err := decoder(signature)
 	if err != nil {
-		return errors.New("unable to decode token signature")
+		return nil, verificationError(ErrMalformedToken, "unable to decode token signature")
 	}
 
-	kid := joseHeader.Kid
-	encodedDer, err := fetchEncodedDer(jwksUrl, kid)
+	input := fmt.Sprintf("%s.%s", header, payload)
+	_, err = verifyJwsSignature(joseHeader, jwksUrl, input, decodedSignature, opts)
 	if err != nil {
-		return errors.New("unable to read JWKS der cert")
+		return nil, err
 	}
 
-	key, err := publicKeyFromEncodedDer(encodedDer)
+	// JWT claims
+
+	err = validateClaims(&claims, opts, time.Now())
 	if err != nil {
-		return errors.New("unable to read public key from der cert")
+		return nil, err
 	}
 
-	alg := joseHeader.Alg
-	input := fmt.Sprintf("%s.%s", header, payload)
-	err = verifySignature(key, alg, input, decodedSignature)
+	if opts.AccessTokenProfile {
+		err = validateAccessTokenClaims(&claims)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &claims, nil
+}
+
+func resolveJwksUrl(opts VerifyOpts) (string, error) {
+	_, err := url.ParseRequestURI(opts.Issuer)
 	if err != nil {
-		return err
+		return "", verificationError(ErrInvalidIssuer, "improperly formatted issuer")
 	}
 
-	// JWT claims
+	if opts.Keys != nil || len(opts.Secrets) > 0 {
+		return "", nil
+	}
 
-	now := time.Now()
-	if !now.Before(jwt.ExpirationTime()) {
-		return errors.New("token expired")
+	if opts.JwksUrl != "" {
+		return opts.JwksUrl, nil
 	}
 
-	if jwt.Iss != issuer {
-		return errors.New("invalid issuer")
+	return fmt.Sprintf("%s/.well-known/jwks.json", strings.TrimSuffix(opts.Issuer, "/")), nil
+}
+
+// verifyJwsSignature resolves the key identified by the header and
+// checks the signature over the signing input with it, the returned
+// signer identifies the key that verified it
+func verifyJwsSignature(header *JoseHeader, jwksUrl string, signingInput string, signature []byte, opts VerifyOpts) (string, error) {
+	if len(opts.Secrets) > 0 {
+		return verifyHmacSignature(header.Alg, signingInput, signature, opts.Secrets, opts.Audience)
+	}
+
+	keys := opts.Keys
+	if keys == nil {
+		keys = NewUrlKeySource(jwksUrl)
+	}
+
+	jwk, err := resolveJwk(header, keys, opts.HeaderKeys)
+	if err != nil {
+		return "", asVerificationError(err, ErrKeyUnavailable)
+	}
+
+	err = verifyKeyAlgorithm(jwk, header.Alg)
+	if err != nil {
+		return "", err
 	}
 
-	aud, err := parseAudience(jwt.Aud)
+	err = verifyJwkCertificate(jwk, header, opts.Certificates, time.Now())
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	if !hasAudience(aud, audience) {
-		return errors.New("invalid audience")
+	key, err := publicKeyFromJwk(jwk)
+	if err != nil {
+		return "", verificationError(ErrKeyUnavailable, "unable to read public key from JWKS key")
+	}
+
+	err = verifySignature(key, header.Alg, signingInput, signature)
+	if err != nil {
+		return "", err
+	}
+
+	// the thumbprint tells keys apart even when kids are reused
+	signer, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", verificationError(ErrKeyUnavailable, "unable to compute key thumbprint")
+	}
+
+	return signer, nil
+}
+
+func validateClaims(claims *Claims, opts VerifyOpts, now time.Time) error {
+	leeway := opts.Leeway
+
+	if claims.Exp == 0 {
+		return claimError(ErrMissingClaim, "exp")
+	}
+
+	if !now.Add(-leeway).Before(claims.ExpirationTime()) {
+		return ErrTokenExpired
+	}
+
+	if claims.Nbf != 0 && now.Add(leeway).Before(claims.NotBefore()) {
+		return ErrTokenNotYetValid
+	}
+
+	if claims.Iat != 0 && now.Add(leeway).Before(claims.IssuedAt()) {
+		return verificationError(ErrTokenNotYetValid, "issued in the future")
+	}
+
+	if opts.MaxAge > 0 {
+		if claims.Iat == 0 {
+			return claimError(ErrMissingClaim, "iat")
+		}
+
+		if now.Sub(claims.IssuedAt()) > opts.MaxAge+leeway {
+			return ErrTokenTooOld
+		}
+	}
+
+	if claims.Iss != opts.Issuer {
+		return ErrInvalidIssuer
+	}
+
+	if !hasAudience(claims.Aud, opts.Audience) {
+		return ErrInvalidAudience
+	}
+
+	for _, name := range opts.RequiredClaims {
+		if !claims.Has(name) {
+			return claimError(ErrMissingClaim, name)
+		}
 	}
 
 	return nil
@@ -166,11 +321,50 @@ func fetchHash(alg string) (crypto.Hash, error) {
 		return crypto.SHA384, nil
 	case "RS512":
 		return crypto.SHA512, nil
+	case "PS256":
+		return crypto.SHA256, nil
+	case "PS384":
+		return crypto.SHA384, nil
+	case "PS512":
+		return crypto.SHA512, nil
+	case "ES256":
+		return crypto.SHA256, nil
+	case "ES384":
+		return crypto.SHA384, nil
+	case "ES512":
+		return crypto.SHA512, nil
 	default:
 		return crypto.SHA256, errors.New("unknown hash algorithm")
 	}
 }
 
+// TokenHash is the base64url encoded left-most half of the hash of value,
+// using the hash of the signing algorithm, as the OpenID Connect at_hash
+// and c_hash claims are computed
+func TokenHash(value string, alg string) (string, error) {
+	var hash crypto.Hash
+	var err error
+
+	switch {
+	case alg == "EdDSA":
+		hash = crypto.SHA512
+	case strings.HasPrefix(alg, "HS"):
+		hash, err = hmacHash(alg)
+	default:
+		hash, err = fetchHash(alg)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	hasher := hash.New()
+	hasher.Write([]byte(value))
+	sum := hasher.Sum(nil)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
+
 func hasAudience(data []string, aud string) bool {
 	has := false
 	for _, val := range data {
@@ -200,7 +394,138 @@ func parseAudience(data interface{}) ([]string, error) {
 	}
 }
 
-func publicKeyFromEncodedDer(encodedDer string) (*rsa.PublicKey, error) {
+func fetchCurve(alg string) (elliptic.Curve, error) {
+	switch alg {
+	case "ES256":
+		return elliptic.P256(), nil
+	case "ES384":
+		return elliptic.P384(), nil
+	case "ES512":
+		return elliptic.P521(), nil
+	default:
+		return nil, errors.New("unknown curve algorithm")
+	}
+}
+
+func curveFromName(crv string) (elliptic.Curve, error) {
+	switch crv {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	default:
+		return nil, errors.New("unknown curve name")
+	}
+}
+
+// publicKeyFromJwk builds the verification key from the x5c leaf
+// certificate when one is published, otherwise from the key parameters
+func publicKeyFromJwk(jwk *Jwk) (crypto.PublicKey, error) {
+	if len(jwk.X5C) > 0 {
+		return publicKeyFromEncodedDer(jwk.X5C[0])
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		return publicKeyFromExponentAndModulus(jwk.E, jwk.N)
+	case "EC":
+		return publicKeyFromCoordinates(jwk.Crv, jwk.X, jwk.Y)
+	case "OKP":
+		return publicKeyFromOctetKeyPair(jwk.Crv, jwk.X)
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+}
+
+func publicKeyFromExponentAndModulus(encodedE string, encodedN string) (*rsa.PublicKey, error) {
+	decoder := base64.RawURLEncoding.DecodeString
+
+	decodedE, err := decoder(encodedE)
+	if err != nil {
+		return nil, err
+	}
+
+	e := new(big.Int).SetBytes(decodedE)
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid public exponent")
+	}
+
+	decodedN, err := decoder(encodedN)
+	if err != nil {
+		return nil, err
+	}
+
+	n := new(big.Int).SetBytes(decodedN)
+	if n.Sign() <= 0 {
+		return nil, errors.New("invalid modulus")
+	}
+
+	var key = &rsa.PublicKey{
+		E: int(e.Int64()),
+		N: n,
+	}
+
+	return key, nil
+}
+
+func publicKeyFromCoordinates(crv string, encodedX string, encodedY string) (*ecdsa.PublicKey, error) {
+	curve, err := curveFromName(crv)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := base64.RawURLEncoding.DecodeString
+
+	decodedX, err := decoder(encodedX)
+	if err != nil {
+		return nil, err
+	}
+
+	decodedY, err := decoder(encodedY)
+	if err != nil {
+		return nil, err
+	}
+
+	size := (curve.Params().BitSize + 7) / 8
+	if len(decodedX) != size || len(decodedY) != size {
+		return nil, errors.New("invalid curve coordinate length")
+	}
+
+	x := new(big.Int).SetBytes(decodedX)
+	y := new(big.Int).SetBytes(decodedY)
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("point not on curve")
+	}
+
+	key := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     x,
+		Y:     y,
+	}
+
+	return key, nil
+}
+
+func publicKeyFromOctetKeyPair(crv string, encodedX string) (ed25519.PublicKey, error) {
+	if crv != "Ed25519" {
+		return nil, errors.New("unknown curve name")
+	}
+
+	decodedX, err := base64.RawURLEncoding.DecodeString(encodedX)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decodedX) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid public key length")
+	}
+
+	return ed25519.PublicKey(decodedX), nil
+}
+
+func publicKeyFromEncodedDer(encodedDer string) (crypto.PublicKey, error) {
 	decoder := base64.StdEncoding.DecodeString
 	decodedDer, err := decoder(encodedDer)
 	if err != nil {
@@ -212,53 +537,104 @@ func publicKeyFromEncodedDer(encodedDer string) (*rsa.PublicKey, error) {
 		return nil, err
 	}
 
-	key, ok := cert.PublicKey.(*rsa.PublicKey)
-	if !ok {
-		return nil, errors.New("not rsa public key")
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return key, nil
+	case *ecdsa.PublicKey:
+		return key, nil
+	case ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, errors.New("unsupported public key type")
 	}
-
-	return key, nil
 }
 
-func verifySignature(key *rsa.PublicKey, alg string, signingInput string, signature []byte) error {
+func verifySignature(key crypto.PublicKey, alg string, signingInput string, signature []byte) error {
+	// EdDSA signs the message itself, there is no separate digest
+	if alg == "EdDSA" {
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return verificationError(ErrInvalidSignature, "not ed25519 public key")
+		}
+
+		if !ed25519.Verify(edKey, []byte(signingInput), signature) {
+			return &VerificationError{Err: ErrInvalidSignature}
+		}
+
+		return nil
+	}
+
 	hash, err := fetchHash(alg)
 	if err != nil {
-		return err
+		return verificationError(ErrAlgorithmNotAllowed, "%s", alg)
 	}
 
 	hasher := hash.New()
 	hasher.Write([]byte(signingInput))
+	digest := hasher.Sum(nil)
 
-	err = rsa.VerifyPKCS1v15(key, hash, hasher.Sum(nil), signature)
+	switch alg {
+	case "RS256", "RS384", "RS512":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return verificationError(ErrInvalidSignature, "not rsa public key")
+		}
 
-	return err
-}
+		if rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature) != nil {
+			return &VerificationError{Err: ErrInvalidSignature}
+		}
 
-// func publicKeyFromExponentAndModulus(encodedE string, encodedN string) (*rsa.PublicKey, error) {
-// 	decoder := base64.RawURLEncoding.DecodeString
+		return nil
+	case "PS256", "PS384", "PS512":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return verificationError(ErrInvalidSignature, "not rsa public key")
+		}
 
-// 	decodedE, err := decoder(encodedE)
-// 	if err != nil {
-// 		return nil, err
-// 	}
+		opts := &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash,
+			Hash:       hash,
+		}
 
-// 	var buffer bytes.Buffer
-// 	buffer.WriteByte(0)
-// 	buffer.Write(decodedE)
-// 	e := binary.BigEndian.Uint32(buffer.Bytes())
+		if rsa.VerifyPSS(rsaKey, hash, digest, signature, opts) != nil {
+			return &VerificationError{Err: ErrInvalidSignature}
+		}
 
-// 	decodedN, err := decoder(encodedN)
-// 	if err != nil {
-// 		return nil, err
-// 	}
+		return nil
+	case "ES256", "ES384", "ES512":
+		ecdsaKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return verificationError(ErrInvalidSignature, "not ecdsa public key")
+		}
 
-// 	n := new(big.Int)
-// 	n.SetBytes(decodedN)
+		return verifyEcdsa(ecdsaKey, alg, digest, signature)
+	default:
+		return verificationError(ErrAlgorithmNotAllowed, "unsupported signing algorithm %s", alg)
+	}
+}
+
+// verifyEcdsa checks a JWS ECDSA signature, which is the fixed-length
+// concatenation R || S rather than an ASN.1 DER sequence
+func verifyEcdsa(key *ecdsa.PublicKey, alg string, digest []byte, signature []byte) error {
+	curve, err := fetchCurve(alg)
+	if err != nil {
+		return verificationError(ErrAlgorithmNotAllowed, "%s", alg)
+	}
 
-// 	var key = &rsa.PublicKey{
-// 		E: int(e),
-// 		N: n,
-// 	}
+	if key.Curve.Params().Name != curve.Params().Name {
+		return verificationError(ErrInvalidSignature, "curve does not match algorithm")
+	}
 
-// 	return key, nil
-// }
+	size := (curve.Params().BitSize + 7) / 8
+	if len(signature) != 2*size {
+		return verificationError(ErrInvalidSignature, "invalid signature length")
+	}
+
+	r := new(big.Int).SetBytes(signature[:size])
+	s := new(big.Int).SetBytes(signature[size:])
+	if !ecdsa.Verify(key, digest, r, s) {
+		return &VerificationError{Err: ErrInvalidSignature}
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package jose
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// JwsJson is a JWS using the JSON Serialization, the flattened syntax is
+// normalized into a single entry of Signatures by ParseJson
+type JwsJson struct {
+	Payload    string             `json:"payload"`
+	Signatures []JwsJsonSignature `json:"signatures,omitempty"`
+
+	Protected string                 `json:"protected,omitempty"`
+	Header    map[string]interface{} `json:"header,omitempty"`
+	Signature string                 `json:"signature,omitempty"`
+}
+
+type JwsJsonSignature struct {
+	Protected string                 `json:"protected,omitempty"`
+	Header    map[string]interface{} `json:"header,omitempty"`
+	Signature string                 `json:"signature"`
+}
+
+// SignaturePolicy decides how many signatures of a JWS JSON document
+// must be valid for the document to be accepted
+type SignaturePolicy struct {
+	// MinValid signatures by distinct keys required, every signature must
+	// be valid when 0
+	MinValid int
+}
+
+// ParseJson reads a JWS using the general or flattened JSON Serialization
+func ParseJson(data []byte) (*JwsJson, error) {
+	var jws JwsJson
+	if err := json.Unmarshal(data, &jws); err != nil {
+		return nil, verificationError(ErrMalformedToken, "unable to parse JWS JSON")
+	}
+
+	flattened := jws.Signature != "" || jws.Protected != "" || jws.Header != nil
+	if flattened && jws.Signatures != nil {
+		return nil, verificationError(ErrMalformedToken, "JWS JSON mixes general and flattened syntax")
+	}
+
+	if flattened {
+		jws.Signatures = []JwsJsonSignature{{
+			Protected: jws.Protected,
+			Header:    jws.Header,
+			Signature: jws.Signature,
+		}}
+		jws.Protected = ""
+		jws.Header = nil
+		jws.Signature = ""
+	}
+
+	if len(jws.Signatures) == 0 {
+		return nil, verificationError(ErrMalformedToken, "JWS JSON has no signatures")
+	}
+
+	return &jws, nil
+}
+
+// VerifyJson returns the verified payload of a JWS using the JSON
+// Serialization format, keys are resolved as they are for compact tokens
+func VerifyJson(data []byte, opts VerifyOpts, policy SignaturePolicy) ([]byte, error) {
+	jws, err := ParseJson(data)
+	if err != nil {
+		return nil, err
+	}
+
+	required := policy.MinValid
+	if required <= 0 {
+		required = len(jws.Signatures)
+	}
+
+	if required > len(jws.Signatures) {
+		return nil, verificationError(ErrInvalidSignature, "%d valid signatures required, %d present", required, len(jws.Signatures))
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return nil, verificationError(ErrMalformedToken, "unable to decode payload")
+	}
+
+	jwksUrl, err := resolveJwksUrl(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// a signature repeated, or a second signature by the same key, must
+	// not count twice towards the policy
+	seen := map[string]bool{}
+	for _, signature := range jws.Signatures {
+		if seen[signature.Signature] {
+			return nil, verificationError(ErrInvalidSignature, "duplicate signature")
+		}
+		seen[signature.Signature] = true
+	}
+
+	signers := map[string]bool{}
+	var lastErr error
+	for _, signature := range jws.Signatures {
+		signer, err := verifyJsonSignature(&signature, jws.Payload, jwksUrl, opts)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		signers[signer] = true
+	}
+
+	if len(signers) < required {
+		detail := fmt.Sprintf("%d of %d required signers valid", len(signers), required)
+		if lastErr == nil {
+			return nil, verificationError(ErrInvalidSignature, "%s", detail)
+		}
+
+		// the last failure keeps its classification, e.g. keys unavailable
+		sentinel := ErrInvalidSignature
+		var verr *VerificationError
+		if errors.As(lastErr, &verr) {
+			sentinel = verr.Err
+		}
+
+		return nil, verificationError(sentinel, "%s: %v", detail, lastErr)
+	}
+
+	return payload, nil
+}
+
+func verifyJsonSignature(signature *JwsJsonSignature, payload string, jwksUrl string, opts VerifyOpts) (string, error) {
+	header, err := mergeJsonHeaders(signature)
+	if err != nil {
+		return "", err
+	}
+
+	err = verifyHeader(header, opts)
+	if err != nil {
+		return "", err
+	}
+
+	decodedSignature, err := base64.RawURLEncoding.DecodeString(signature.Signature)
+	if err != nil {
+		return "", verificationError(ErrMalformedToken, "unable to decode signature")
+	}
+
+	input := fmt.Sprintf("%s.%s", signature.Protected, payload)
+
+	return verifyJwsSignature(header, jwksUrl, input, decodedSignature, opts)
+}
+
+// mergeJsonHeaders returns the JOSE header of a signature, the union of
+// its protected and unprotected members which must be disjoint. The alg
+// must be integrity protected so it cannot be swapped by an intermediary
+func mergeJsonHeaders(signature *JwsJsonSignature) (*JoseHeader, error) {
+	protected := map[string]interface{}{}
+	if signature.Protected != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(signature.Protected)
+		if err != nil {
+			return nil, verificationError(ErrInvalidHeader, "unable to decode protected header")
+		}
+
+		if err := json.Unmarshal(decoded, &protected); err != nil {
+			return nil, verificationError(ErrInvalidHeader, "unable to parse protected header")
+		}
+	}
+
+	if _, ok := protected["alg"]; !ok {
+		return nil, &VerificationError{Err: ErrInvalidHeader, Claim: "alg", Detail: "alg missing from protected header"}
+	}
+
+	if _, ok := signature.Header["crit"]; ok {
+		return nil, &VerificationError{Err: ErrInvalidHeader, Claim: "crit", Detail: "crit must be integrity protected"}
+	}
+
+	merged := map[string]interface{}{}
+	for name, value := range protected {
+		merged[name] = value
+	}
+
+	for name, value := range signature.Header {
+		if _, ok := merged[name]; ok {
+			return nil, &VerificationError{Err: ErrInvalidHeader, Claim: name, Detail: "duplicate header parameter " + name}
+		}
+		merged[name] = value
+	}
+
+	// no critical extensions are understood for JSON serialization
+	err := checkCritical(merged, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+
+	var header JoseHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, verificationError(ErrInvalidHeader, "unable to parse header")
+	}
+
+	return &header, nil
+}
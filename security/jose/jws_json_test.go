@@ -0,0 +1,123 @@
+package jose
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVerifyJson(t *testing.T) {
+	trusted, err := GenerateSigningKey("ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	untrusted, err := GenerateSigningKey("ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk, _ := NewJwk(trusted.Key.Public())
+	jwk.Kid = trusted.Kid
+	jwks := JwkSet{Keys: []Jwk{*jwk}}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwks)
+	}))
+
+	defer ts.Close()
+
+	opts := VerifyOpts{Issuer: ts.URL + "/"}
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"event":"user.created"}`))
+
+	trustedSignature := signJsonSignature(t, trusted, payload)
+	resignedSignature := signJsonSignature(t, trusted, payload)
+	untrustedSignature := signJsonSignature(t, untrusted, payload)
+
+	general := func(signatures ...JwsJsonSignature) []byte {
+		data, _ := json.Marshal(JwsJson{Payload: payload, Signatures: signatures})
+		return data
+	}
+
+	flattened, _ := json.Marshal(map[string]interface{}{
+		"payload":   payload,
+		"protected": trustedSignature.Protected,
+		"header":    trustedSignature.Header,
+		"signature": trustedSignature.Signature,
+	})
+
+	testCases := []struct {
+		name   string
+		data   []byte
+		policy SignaturePolicy
+		valid  bool
+	}{
+		{"flattened", flattened, SignaturePolicy{}, true},
+		{"general single", general(trustedSignature), SignaturePolicy{}, true},
+		{"general all required", general(trustedSignature, untrustedSignature), SignaturePolicy{}, false},
+		{"general one required", general(trustedSignature, untrustedSignature), SignaturePolicy{MinValid: 1}, true},
+		{"general two required", general(trustedSignature, untrustedSignature), SignaturePolicy{MinValid: 2}, false},
+		{"untrusted only", general(untrustedSignature), SignaturePolicy{MinValid: 1}, false},
+		{"more required than present", general(trustedSignature), SignaturePolicy{MinValid: 2}, false},
+		{"duplicated trusted signature, MinValid 2", general(trustedSignature, trustedSignature), SignaturePolicy{MinValid: 2}, false},
+		{"same key signed twice, MinValid 2", general(trustedSignature, resignedSignature), SignaturePolicy{MinValid: 2}, false},
+		{"same key signed twice, all required", general(trustedSignature, resignedSignature), SignaturePolicy{}, false},
+		{"no signatures", general(), SignaturePolicy{}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			verified, err := VerifyJson(tc.data, opts, tc.policy)
+			if tc.valid && (err != nil || string(verified) != `{"event":"user.created"}`) {
+				t.Fatalf(`VerifyJson() = %q, %v, want match for payload, nil`, verified, err)
+			}
+			var verr *VerificationError
+			if !tc.valid && !errors.As(err, &verr) {
+				t.Fatalf(`VerifyJson() = %q, %v, want verification error`, verified, err)
+			}
+		})
+	}
+}
+
+func TestMergeJsonHeaders(t *testing.T) {
+	encoder := base64.RawURLEncoding.EncodeToString
+
+	testCases := []struct {
+		name      string
+		signature JwsJsonSignature
+		valid     bool
+	}{
+		{"kid unprotected", JwsJsonSignature{Protected: encoder([]byte(`{"alg":"ES256"}`)), Header: map[string]interface{}{"kid": "k1"}}, true},
+		{"alg unprotected", JwsJsonSignature{Header: map[string]interface{}{"alg": "ES256", "kid": "k1"}}, false},
+		{"duplicate member", JwsJsonSignature{Protected: encoder([]byte(`{"alg":"ES256","kid":"k1"}`)), Header: map[string]interface{}{"kid": "k2"}}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header, err := mergeJsonHeaders(&tc.signature)
+			if tc.valid != (err == nil) || (err != nil && !errors.Is(err, ErrInvalidHeader)) {
+				t.Fatalf(`mergeJsonHeaders() = %+v, %v, want valid %t`, header, err, tc.valid)
+			}
+		})
+	}
+}
+
+func signJsonSignature(t *testing.T, key *SigningKey, payload string) JwsJsonSignature {
+	encoder := base64.RawURLEncoding.EncodeToString
+	protected := encoder([]byte(fmt.Sprintf(`{"alg":%q}`, key.Alg)))
+
+	signature, err := createSignature(key.Key, key.Alg, protected+"."+payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return JwsJsonSignature{
+		Protected: protected,
+		Header:    map[string]interface{}{"kid": key.Kid},
+		Signature: encoder(signature),
+	}
+}
@@ -2,7 +2,17 @@ package jose
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestFetchHashEmpty(t *testing.T) {
@@ -77,3 +87,475 @@ func TestParseAudienceArray(t *testing.T) {
 		t.Fatalf(`parseAudience("") = %q, %v, want match for %#q, nil`, audience, err, want)
 	}
 }
+
+func TestFetchHashES256(t *testing.T) {
+	alg := "ES256"
+	want := crypto.SHA256
+	hash, err := fetchHash(alg)
+	if hash != want || err != nil {
+		t.Fatalf(`fetchHash("ES256") = %q, %v, want match for %#q, nil`, hash, err, want)
+	}
+}
+
+func TestFetchHashES384(t *testing.T) {
+	alg := "ES384"
+	want := crypto.SHA384
+	hash, err := fetchHash(alg)
+	if hash != want || err != nil {
+		t.Fatalf(`fetchHash("ES384") = %q, %v, want match for %#q, nil`, hash, err, want)
+	}
+}
+
+func TestFetchHashES512(t *testing.T) {
+	alg := "ES512"
+	want := crypto.SHA512
+	hash, err := fetchHash(alg)
+	if hash != want || err != nil {
+		t.Fatalf(`fetchHash("ES512") = %q, %v, want match for %#q, nil`, hash, err, want)
+	}
+}
+
+func TestVerifySignatureEcdsa(t *testing.T) {
+	testCases := []struct {
+		alg   string
+		curve elliptic.Curve
+	}{
+		{"ES256", elliptic.P256()},
+		{"ES384", elliptic.P384()},
+		{"ES512", elliptic.P521()},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.alg, func(t *testing.T) {
+			key, err := ecdsa.GenerateKey(tc.curve, rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			input := "header.payload"
+			signature := signEcdsa(t, key, tc.alg, input)
+
+			err = verifySignature(&key.PublicKey, tc.alg, input, signature)
+			if err != nil {
+				t.Fatalf(`verifySignature(%q) = %v, want match for nil`, tc.alg, err)
+			}
+
+			err = verifySignature(&key.PublicKey, tc.alg, "header.tampered", signature)
+			if err == nil {
+				t.Fatalf(`verifySignature(%q) = nil, want error for tampered input`, tc.alg)
+			}
+		})
+	}
+}
+
+func TestVerifySignatureEcdsaWrongCurve(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "header.payload"
+	signature := signEcdsa(t, key, "ES384", input)
+
+	err = verifySignature(&key.PublicKey, "ES256", input, signature)
+	if err == nil {
+		t.Fatalf(`verifySignature("ES256") = nil, want error for P-384 key`)
+	}
+}
+
+func TestVerifySignatureEcdsaRsaKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = verifySignature(&key.PublicKey, "ES256", "header.payload", make([]byte, 64))
+	if err == nil {
+		t.Fatalf(`verifySignature("ES256") = nil, want error for rsa key`)
+	}
+}
+
+func TestPublicKeyFromCoordinates(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoder := base64.RawURLEncoding.EncodeToString
+	x := encoder(key.X.FillBytes(make([]byte, 32)))
+	y := encoder(key.Y.FillBytes(make([]byte, 32)))
+
+	pub, err := publicKeyFromCoordinates("P-256", x, y)
+	if err != nil || !pub.Equal(&key.PublicKey) {
+		t.Fatalf(`publicKeyFromCoordinates("P-256") = %v, %v, want match for key, nil`, pub, err)
+	}
+
+	_, err = publicKeyFromCoordinates("P-384", x, y)
+	if err == nil {
+		t.Fatalf(`publicKeyFromCoordinates("P-384") = _, nil, want error for P-256 coordinates`)
+	}
+}
+
+func signEcdsa(t *testing.T, key *ecdsa.PrivateKey, alg string, input string) []byte {
+	hash, err := fetchHash(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hasher := hash.New()
+	hasher.Write([]byte(input))
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, hasher.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := (key.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+
+	return signature
+}
+
+func TestFetchHashPS256(t *testing.T) {
+	alg := "PS256"
+	want := crypto.SHA256
+	hash, err := fetchHash(alg)
+	if hash != want || err != nil {
+		t.Fatalf(`fetchHash("PS256") = %q, %v, want match for %#q, nil`, hash, err, want)
+	}
+}
+
+func TestFetchHashPS384(t *testing.T) {
+	alg := "PS384"
+	want := crypto.SHA384
+	hash, err := fetchHash(alg)
+	if hash != want || err != nil {
+		t.Fatalf(`fetchHash("PS384") = %q, %v, want match for %#q, nil`, hash, err, want)
+	}
+}
+
+func TestFetchHashPS512(t *testing.T) {
+	alg := "PS512"
+	want := crypto.SHA512
+	hash, err := fetchHash(alg)
+	if hash != want || err != nil {
+		t.Fatalf(`fetchHash("PS512") = %q, %v, want match for %#q, nil`, hash, err, want)
+	}
+}
+
+func TestVerifySignatureRsaPss(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, alg := range []string{"PS256", "PS384", "PS512"} {
+		t.Run(alg, func(t *testing.T) {
+			input := "header.payload"
+			hash, _ := fetchHash(alg)
+			hasher := hash.New()
+			hasher.Write([]byte(input))
+
+			opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+			signature, err := rsa.SignPSS(rand.Reader, key, hash, hasher.Sum(nil), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = verifySignature(&key.PublicKey, alg, input, signature)
+			if err != nil {
+				t.Fatalf(`verifySignature(%q) = %v, want match for nil`, alg, err)
+			}
+
+			rsAlg := "RS" + alg[2:]
+			err = verifySignature(&key.PublicKey, rsAlg, input, signature)
+			if err == nil {
+				t.Fatalf(`verifySignature(%q) = nil, want error for PSS signature`, rsAlg)
+			}
+		})
+	}
+}
+
+func TestVerifySignatureEdDSA(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "header.payload"
+	signature := ed25519.Sign(priv, []byte(input))
+
+	err = verifySignature(pub, "EdDSA", input, signature)
+	if err != nil {
+		t.Fatalf(`verifySignature("EdDSA") = %v, want match for nil`, err)
+	}
+
+	err = verifySignature(pub, "EdDSA", "header.tampered", signature)
+	if err == nil {
+		t.Fatalf(`verifySignature("EdDSA") = nil, want error for tampered input`)
+	}
+}
+
+func TestPublicKeyFromJwkOctetKeyPair(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk := &Jwk{
+		Kty: "OKP",
+		Crv: "Ed25519",
+		X:   base64.RawURLEncoding.EncodeToString(pub),
+	}
+
+	key, err := publicKeyFromJwk(jwk)
+	if err != nil || !pub.Equal(key) {
+		t.Fatalf(`publicKeyFromJwk(OKP) = %v, %v, want match for key, nil`, key, err)
+	}
+
+	jwk.Crv = "X25519"
+	_, err = publicKeyFromJwk(jwk)
+	if err == nil {
+		t.Fatalf(`publicKeyFromJwk(X25519) = _, nil, want error`)
+	}
+}
+
+func TestPublicKeyFromJwkRsa(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoder := base64.RawURLEncoding.EncodeToString
+	jwk := &Jwk{
+		Kty: "RSA",
+		N:   encoder(key.N.Bytes()),
+		E:   "AQAB",
+	}
+
+	pub, err := publicKeyFromJwk(jwk)
+	if err != nil || !key.PublicKey.Equal(pub) {
+		t.Fatalf(`publicKeyFromJwk(RSA) = %v, %v, want match for key, nil`, pub, err)
+	}
+}
+
+func TestPublicKeyFromJwkUnknownKty(t *testing.T) {
+	jwk := &Jwk{Kty: "oct"}
+	_, err := publicKeyFromJwk(jwk)
+	if err == nil {
+		t.Fatalf(`publicKeyFromJwk(oct) = _, nil, want error`)
+	}
+}
+
+func TestVerifyCompactWithoutX5c(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoder := base64.RawURLEncoding.EncodeToString
+	jwks := JwkSet{
+		Keys: []Jwk{
+			{
+				Alg: "RS256",
+				Kty: "RSA",
+				Use: "sig",
+				N:   encoder(rsaKey.N.Bytes()),
+				E:   "AQAB",
+				Kid: "no-x5c-rsa",
+			},
+			{
+				Alg: "ES256",
+				Kty: "EC",
+				Use: "sig",
+				Crv: "P-256",
+				X:   encoder(ecKey.X.FillBytes(make([]byte, 32))),
+				Y:   encoder(ecKey.Y.FillBytes(make([]byte, 32))),
+				Kid: "no-x5c-ec",
+			},
+		},
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwks)
+	}))
+
+	defer ts.Close()
+
+	issuer := ts.URL + "/"
+	claims := map[string]interface{}{
+		"iss": issuer,
+		"aud": "audience",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	testCases := []struct {
+		name  string
+		token string
+	}{
+		{"rsa", signCompact(t, rsaKey, "RS256", "no-x5c-rsa", claims)},
+		{"ec", signCompact(t, ecKey, "ES256", "no-x5c-ec", claims)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims, err := VerifyCompact(tc.token, VerifyOpts{Issuer: issuer, Audience: "audience"})
+			if err != nil || claims.Iss != issuer {
+				t.Fatalf(`VerifyCompact(%s) = %+v, %v, want match for claims, nil`, tc.name, claims, err)
+			}
+		})
+	}
+}
+
+func signCompact(t *testing.T, key crypto.Signer, alg string, kid string, claims map[string]interface{}) string {
+	encoder := base64.RawURLEncoding.EncodeToString
+
+	header, _ := json.Marshal(JoseHeader{Alg: alg, Kid: kid, Typ: "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := encoder(header) + "." + encoder(payload)
+
+	var signature []byte
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		signature = signEcdsa(t, k, alg, input)
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, []byte(input))
+	case *rsa.PrivateKey:
+		hash, err := fetchHash(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hasher := hash.New()
+		hasher.Write([]byte(input))
+
+		if alg[0] == 'P' {
+			opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+			signature, err = rsa.SignPSS(rand.Reader, k, hash, hasher.Sum(nil), opts)
+		} else {
+			signature, err = rsa.SignPKCS1v15(rand.Reader, k, hash, hasher.Sum(nil))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return input + "." + encoder(signature)
+}
+
+func TestValidateClaims(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	opts := VerifyOpts{
+		Issuer:   "https://issuer/",
+		Audience: "audience",
+		Leeway:   time.Second * 30,
+	}
+
+	valid := func() Claims {
+		return Claims{
+			Iss:    "https://issuer/",
+			Sub:    "subject",
+			Aud:    []string{"audience"},
+			Exp:    now.Add(time.Hour).Unix(),
+			Iat:    now.Add(-time.Minute).Unix(),
+			Custom: map[string]interface{}{"azp": "client"},
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		mutate func(*Claims, *VerifyOpts)
+		valid  bool
+	}{
+		{"valid", func(c *Claims, o *VerifyOpts) {}, true},
+		{"missing exp", func(c *Claims, o *VerifyOpts) { c.Exp = 0 }, false},
+		{"expired", func(c *Claims, o *VerifyOpts) { c.Exp = now.Add(-time.Minute).Unix() }, false},
+		{"expired within leeway", func(c *Claims, o *VerifyOpts) { c.Exp = now.Add(-time.Second * 10).Unix() }, true},
+		{"expired without leeway", func(c *Claims, o *VerifyOpts) { c.Exp = now.Unix(); o.Leeway = 0 }, false},
+		{"not yet valid", func(c *Claims, o *VerifyOpts) { c.Nbf = now.Add(time.Minute).Unix() }, false},
+		{"not yet valid within leeway", func(c *Claims, o *VerifyOpts) { c.Nbf = now.Add(time.Second * 10).Unix() }, true},
+		{"issued in future", func(c *Claims, o *VerifyOpts) { c.Iat = now.Add(time.Minute).Unix() }, false},
+		{"issued in future within leeway", func(c *Claims, o *VerifyOpts) { c.Iat = now.Add(time.Second * 10).Unix() }, true},
+		{"max age", func(c *Claims, o *VerifyOpts) { o.MaxAge = time.Minute * 5 }, true},
+		{"max age exceeded", func(c *Claims, o *VerifyOpts) { o.MaxAge = time.Second * 10 }, false},
+		{"max age missing iat", func(c *Claims, o *VerifyOpts) { c.Iat = 0; o.MaxAge = time.Hour }, false},
+		{"required claims", func(c *Claims, o *VerifyOpts) { o.RequiredClaims = []string{"sub", "azp"} }, true},
+		{"missing required claim", func(c *Claims, o *VerifyOpts) { o.RequiredClaims = []string{"jti"} }, false},
+		{"invalid issuer", func(c *Claims, o *VerifyOpts) { c.Iss = "https://other/" }, false},
+		{"invalid audience", func(c *Claims, o *VerifyOpts) { c.Aud = []string{"other"} }, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := valid()
+			tcOpts := opts
+			tc.mutate(&claims, &tcOpts)
+
+			err := validateClaims(&claims, tcOpts, now)
+			if tc.valid != (err == nil) {
+				t.Fatalf(`validateClaims() = %v, want valid %t`, err, tc.valid)
+			}
+		})
+	}
+}
+
+func TestIsCompactJws(t *testing.T) {
+	testCases := []struct {
+		token string
+		want  bool
+	}{
+		{"eyJhbGciOiJSUzI1NiJ9.e30.c2ln", true},
+		{"2YotnFZFEjr1zCsicMWpAA", false},
+		{"opaque.with.dots", false},
+		{"a.b.c.d.e", false},
+	}
+
+	for _, tc := range testCases {
+		got := IsCompactJws(tc.token)
+		if got != tc.want {
+			t.Fatalf(`IsCompactJws(%q) = %v, want match for %v`, tc.token, got, tc.want)
+		}
+	}
+}
+
+func TestTokenHash(t *testing.T) {
+	testCases := []struct {
+		name  string
+		value string
+		alg   string
+		want  string
+	}{
+		{"at_hash", "jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y", "RS256", "77QmUPtjPfzWtF2AnpK9RQ"},
+		{"c_hash", "Qcb0Orv1zh30vL1MPRsbm-diHiMwcLyZvn1arpZv-Jxf_11jnpEX3Tgfvk", "RS256", "LDktKdoQak3Pk0cnXxCltA"},
+		{"hmac", "jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y", "HS256", "77QmUPtjPfzWtF2AnpK9RQ"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := TokenHash(tc.value, tc.alg)
+			if err != nil || got != tc.want {
+				t.Fatalf(`TokenHash(%q) = %q, %v, want match for %q, nil`, tc.alg, got, err, tc.want)
+			}
+		})
+	}
+
+	// half of SHA-384 and SHA-512, EdDSA hashes with SHA-512
+	for alg, size := range map[string]int{"ES384": 32, "EdDSA": 43} {
+		got, _ := TokenHash("value", alg)
+		if len(got) != size {
+			t.Fatalf(`TokenHash(%q) = %q, want match for %d characters`, alg, got, size)
+		}
+	}
+
+	_, err := TokenHash("value", "none")
+	if err == nil {
+		t.Fatalf(`TokenHash("none") = _, nil, want error`)
+	}
+}
@@ -0,0 +1,339 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type KeyManagerOpts struct {
+	// Alg of the keys generated on rotation
+	Alg string
+	// RotationPeriod after which a new signing key is generated, 0
+	// disables rotation
+	RotationPeriod time.Duration
+	// GracePeriod during which a retired key is still published so
+	// tokens it signed can be verified until they expire
+	GracePeriod time.Duration
+	// PublishAhead is how long the next key is published before it signs,
+	// at least the max-age relying parties cache the JWKS for
+	PublishAhead time.Duration
+}
+
+// KeyManager owns the locally managed signing keys, it signs with the
+// current key and publishes the next, current and recently retired public
+// keys. Generated keys only live in memory, so rotation is meant for a
+// single instance, replicas must share keys loaded from a file instead
+type KeyManager struct {
+	opts    KeyManagerOpts
+	current *managedKey
+	next    *managedKey
+	nextAt  time.Time
+	retired []*managedKey
+	mtx     sync.Mutex
+	now     func() time.Time
+}
+
+type managedKey struct {
+	key       *SigningKey
+	createdAt time.Time
+	retiredAt time.Time
+}
+
+// NewKeyManager starts with the loaded keys, the last one being used for
+// signing, or generates a key when none are given
+func NewKeyManager(opts KeyManagerOpts, keys ...*SigningKey) (*KeyManager, error) {
+	km := KeyManager{
+		opts:    opts,
+		retired: []*managedKey{},
+		mtx:     sync.Mutex{},
+		now:     time.Now,
+	}
+
+	now := km.now()
+	for i, key := range keys {
+		if err := checkSigningKey(key); err != nil {
+			return nil, err
+		}
+
+		managed := &managedKey{key: key, createdAt: now}
+		if i < len(keys)-1 {
+			managed.retiredAt = now
+			km.retired = append(km.retired, managed)
+		} else {
+			km.current = managed
+		}
+	}
+
+	// nothing was published before, the first key signs right away
+	if km.current == nil {
+		key, err := GenerateSigningKey(opts.Alg)
+		if err != nil {
+			return nil, err
+		}
+
+		km.current = &managedKey{key: key, createdAt: now}
+	}
+
+	return &km, nil
+}
+
+// SigningKey returns the current signing key, rotating it first when
+// the rotation period elapsed
+func (km *KeyManager) SigningKey() (*SigningKey, error) {
+	km.mtx.Lock()
+	defer km.mtx.Unlock()
+
+	err := km.maintain(km.now())
+	if err != nil {
+		return nil, err
+	}
+
+	return km.current.key, nil
+}
+
+// Sign returns the claims signed with the current signing key
+func (km *KeyManager) Sign(claims *Claims, typ string) (string, error) {
+	key, err := km.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	return SignCompact(claims, key, typ)
+}
+
+// SignDetached returns a detached, unencoded payload JWS over the payload
+// signed with the current signing key
+func (km *KeyManager) SignDetached(payload []byte) (string, error) {
+	key, err := km.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	return SignDetached(payload, key)
+}
+
+// Rotate publishes a new key, it replaces the current signing key once
+// PublishAhead elapsed so relying parties have seen it in the JWKS
+func (km *KeyManager) Rotate() error {
+	km.mtx.Lock()
+	defer km.mtx.Unlock()
+
+	now := km.now()
+	if km.next == nil {
+		if err := km.prepare(now.Add(km.opts.PublishAhead)); err != nil {
+			return err
+		}
+	}
+
+	return km.maintain(now)
+}
+
+// PublicJwkSet returns the public keys that verify tokens signed by this
+// manager, the current key and retired keys still in their grace period
+func (km *KeyManager) PublicJwkSet() (*JwkSet, error) {
+	km.mtx.Lock()
+	defer km.mtx.Unlock()
+
+	err := km.maintain(km.now())
+	if err != nil {
+		return nil, err
+	}
+
+	published := []*managedKey{km.current}
+	if km.next != nil {
+		published = append(published, km.next)
+	}
+
+	jwks := JwkSet{Keys: []Jwk{}}
+	for _, managed := range append(published, km.retired...) {
+		jwk, err := NewJwk(managed.key.Key.Public())
+		if err != nil {
+			return nil, err
+		}
+
+		jwk.Kid = managed.key.Kid
+		jwk.Alg = managed.key.Alg
+		jwk.Use = "sig"
+		jwks.Keys = append(jwks.Keys, *jwk)
+	}
+
+	return &jwks, nil
+}
+
+func (km *KeyManager) maintain(now time.Time) error {
+	if km.opts.RotationPeriod > 0 && km.next == nil {
+		rotateAt := km.current.createdAt.Add(km.opts.RotationPeriod)
+		if !now.Before(rotateAt.Add(-km.opts.PublishAhead)) {
+			// a late check still publishes the key for PublishAhead
+			activateAt := now.Add(km.opts.PublishAhead)
+			if rotateAt.After(activateAt) {
+				activateAt = rotateAt
+			}
+
+			if err := km.prepare(activateAt); err != nil {
+				return err
+			}
+		}
+	}
+
+	if km.next != nil && !now.Before(km.nextAt) {
+		km.promote(now)
+	}
+
+	retired := []*managedKey{}
+	for _, managed := range km.retired {
+		if now.Sub(managed.retiredAt) < km.opts.GracePeriod {
+			retired = append(retired, managed)
+		}
+	}
+	km.retired = retired
+
+	return nil
+}
+
+// prepare generates the next key, published now and signing from activateAt
+func (km *KeyManager) prepare(activateAt time.Time) error {
+	key, err := GenerateSigningKey(km.opts.Alg)
+	if err != nil {
+		return err
+	}
+
+	km.next = &managedKey{key: key}
+	km.nextAt = activateAt
+
+	return nil
+}
+
+// promote retires the current signing key in favour of the next one
+func (km *KeyManager) promote(now time.Time) {
+	km.current.retiredAt = now
+	km.retired = append([]*managedKey{km.current}, km.retired...)
+
+	km.current = &managedKey{key: km.next.key, createdAt: now}
+	km.next = nil
+}
+
+// GenerateSigningKey returns a new random key for the algorithm
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	var key crypto.Signer
+	var err error
+
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256", "ES384", "ES512":
+		var curve elliptic.Curve
+		curve, err = fetchCurve(alg)
+		if err == nil {
+			key, err = ecdsa.GenerateKey(curve, rand.Reader)
+		}
+	case "EdDSA":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	kid, err := thumbprintKid(key)
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey := SigningKey{
+		Kid: kid,
+		Alg: alg,
+		Key: key,
+	}
+
+	return &signingKey, nil
+}
+
+// ParseSigningKeys reads the PEM encoded private keys used to sign with
+// the algorithm, the kid is taken from the "kid" PEM header and defaults
+// to the RFC 7638 thumbprint of the key
+func ParseSigningKeys(pemData []byte, alg string) ([]*SigningKey, error) {
+	keys := []*SigningKey{}
+
+	for {
+		var block *pem.Block
+		block, pemData = pem.Decode(pemData)
+		if block == nil {
+			break
+		}
+
+		privateKey, err := parsePrivateKey(block)
+		if err != nil {
+			return nil, err
+		}
+
+		signer, ok := privateKey.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+
+		kid := block.Headers["kid"]
+		if kid == "" {
+			kid, err = thumbprintKid(signer)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		key := &SigningKey{
+			Kid: kid,
+			Alg: alg,
+			Key: signer,
+		}
+
+		if err := checkSigningKey(key); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no private keys found")
+	}
+
+	return keys, nil
+}
+
+func checkSigningKey(key *SigningKey) error {
+	if key.Kid == "" {
+		return errors.New("signing key kid required")
+	}
+
+	jwk, err := NewJwk(key.Key.Public())
+	if err != nil {
+		return err
+	}
+
+	if jwk.Kty != keyTypeForAlgorithm(key.Alg) {
+		return errors.New("key type does not match signing algorithm")
+	}
+
+	return nil
+}
+
+// thumbprintKid returns the SHA-256 JWK thumbprint of the key
+func thumbprintKid(key crypto.Signer) (string, error) {
+	jwk, err := NewJwk(key.Public())
+	if err != nil {
+		return "", err
+	}
+
+	return jwk.Thumbprint(crypto.SHA256)
+}
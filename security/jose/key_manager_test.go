@@ -0,0 +1,143 @@
+package jose
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+func TestKeyManagerRotation(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	km, err := NewKeyManager(KeyManagerOpts{
+		Alg:            "EdDSA",
+		RotationPeriod: time.Hour,
+		GracePeriod:    time.Minute * 30,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	km.now = func() time.Time { return now }
+	km.current.createdAt = now
+
+	first, _ := km.SigningKey()
+
+	now = now.Add(time.Hour)
+	second, _ := km.SigningKey()
+	if first.Kid == second.Kid {
+		t.Fatalf(`SigningKey() = %q, want rotated key after rotation period`, second.Kid)
+	}
+
+	jwks, err := km.PublicJwkSet()
+	if err != nil || len(jwks.Keys) != 2 {
+		t.Fatalf(`PublicJwkSet() = %+v, %v, want current and retired key`, jwks, err)
+	}
+
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "OKP" || jwk.Use != "sig" || jwk.Alg != "EdDSA" {
+			t.Fatalf(`PublicJwkSet() key = %+v, want public EdDSA signing key`, jwk)
+		}
+	}
+
+	now = now.Add(time.Minute * 31)
+	jwks, err = km.PublicJwkSet()
+	if err != nil || len(jwks.Keys) != 1 || jwks.Keys[0].Kid != second.Kid {
+		t.Fatalf(`PublicJwkSet() = %+v, %v, want retired key dropped after grace period`, jwks, err)
+	}
+}
+
+func TestKeyManagerPublishAhead(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	km, err := NewKeyManager(KeyManagerOpts{
+		Alg:            "EdDSA",
+		RotationPeriod: time.Hour,
+		GracePeriod:    time.Minute * 30,
+		PublishAhead:   time.Minute * 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	km.now = func() time.Time { return now }
+	km.current.createdAt = now
+
+	first, _ := km.SigningKey()
+
+	// published 5 minutes ahead of the rotation, still not signing
+	now = now.Add(time.Minute * 55)
+	jwks, err := km.PublicJwkSet()
+	if err != nil || len(jwks.Keys) != 2 {
+		t.Fatalf(`PublicJwkSet() = %+v, %v, want current and next key`, jwks, err)
+	}
+
+	next := jwks.Keys[1].Kid
+	signing, _ := km.SigningKey()
+	if signing.Kid != first.Kid || next == first.Kid {
+		t.Fatalf(`SigningKey() = %q, want %q until the next key was published for PublishAhead`, signing.Kid, first.Kid)
+	}
+
+	now = now.Add(time.Minute * 5)
+	signing, _ = km.SigningKey()
+	if signing.Kid != next {
+		t.Fatalf(`SigningKey() = %q, want published next key %q`, signing.Kid, next)
+	}
+
+	// an explicit rotation is published before it signs as well
+	err = km.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks, _ = km.PublicJwkSet()
+	rotated := jwks.Keys[1].Kid
+	signing, _ = km.SigningKey()
+	if signing.Kid != next {
+		t.Fatalf(`SigningKey() = %q, want %q right after Rotate()`, signing.Kid, next)
+	}
+
+	now = now.Add(time.Minute * 5)
+	signing, _ = km.SigningKey()
+	if signing.Kid != rotated {
+		t.Fatalf(`SigningKey() = %q, want rotated key %q after PublishAhead`, signing.Kid, rotated)
+	}
+}
+
+func TestParseSigningKeys(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pemData := pem.EncodeToMemory(&pem.Block{
+		Type:    "EC PRIVATE KEY",
+		Headers: map[string]string{"kid": "sig1"},
+		Bytes:   der,
+	})
+
+	keys, err := ParseSigningKeys(pemData, "ES256")
+	if err != nil || len(keys) != 1 || keys[0].Kid != "sig1" {
+		t.Fatalf(`ParseSigningKeys(ES256) = %+v, %v, want match for sig1, nil`, keys, err)
+	}
+
+	_, err = ParseSigningKeys(pemData, "RS256")
+	if err == nil {
+		t.Fatalf(`ParseSigningKeys(RS256) = _, nil, want error for ec key`)
+	}
+
+	km, err := NewKeyManager(KeyManagerOpts{Alg: "ES256"}, keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ := km.SigningKey()
+	if current.Kid != "sig1" {
+		t.Fatalf(`SigningKey() = %q, want match for loaded key sig1`, current.Kid)
+	}
+}
@@ -0,0 +1,231 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"dahbura.me/api/config"
+)
+
+// KeySource resolves the key that verifies a signature by its kid
+type KeySource interface {
+	Key(kid string) (*Jwk, error)
+}
+
+type urlKeySource struct {
+	jwksUrl string
+}
+
+// NewUrlKeySource fetches keys from the JWKS at jwksUrl through the
+// shared JWKS cache
+func NewUrlKeySource(jwksUrl string) KeySource {
+	return &urlKeySource{jwksUrl: jwksUrl}
+}
+
+func (source *urlKeySource) Key(kid string) (*Jwk, error) {
+	return fetchJwk(source.jwksUrl, kid)
+}
+
+type staticKeySource struct {
+	jwks *JwkSet
+}
+
+// NewStaticKeySource serves a fixed key set, such as an inline JWKS from
+// configuration, and never goes to the network
+func NewStaticKeySource(jwks *JwkSet) KeySource {
+	return &staticKeySource{jwks: jwks.Public()}
+}
+
+func (source *staticKeySource) Key(kid string) (*Jwk, error) {
+	return findStaticJwk(source.jwks, kid)
+}
+
+// NewPinnedKeySource serves the PEM encoded public keys or certificates,
+// the kid is taken from the "kid" PEM header and defaults to the RFC 7638
+// thumbprint of the key
+func NewPinnedKeySource(pemData []byte) (KeySource, error) {
+	jwks := JwkSet{Keys: []Jwk{}}
+
+	for {
+		var block *pem.Block
+		block, pemData = pem.Decode(pemData)
+		if block == nil {
+			break
+		}
+
+		var publicKey interface{}
+		var err error
+
+		switch block.Type {
+		case "PUBLIC KEY":
+			publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				publicKey = cert.PublicKey
+			}
+		default:
+			err = errors.New("unsupported pem block type")
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		jwk, err := NewJwk(publicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		jwk.Kid = block.Headers["kid"]
+		if jwk.Kid == "" {
+			jwk.Kid, err = jwk.Thumbprint(crypto.SHA256)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		jwks.Keys = append(jwks.Keys, *jwk)
+	}
+
+	if len(jwks.Keys) == 0 {
+		return nil, errors.New("no public keys found")
+	}
+
+	return NewStaticKeySource(&jwks), nil
+}
+
+// FileKeySource serves a JWKS file, reloaded when it changes on disk
+type FileKeySource struct {
+	path      string
+	jwks      *JwkSet
+	modTime   time.Time
+	size      int64
+	lastCheck time.Time
+	reloadErr error
+	mtx       sync.Mutex
+}
+
+func NewFileKeySource(path string) (*FileKeySource, error) {
+	source := FileKeySource{
+		path: path,
+		mtx:  sync.Mutex{},
+	}
+
+	err := source.reload(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return &source, nil
+}
+
+func (source *FileKeySource) Key(kid string) (*Jwk, error) {
+	source.mtx.Lock()
+	defer source.mtx.Unlock()
+
+	// a broken file keeps the last good key set in use, the error is
+	// reported with the keys it may be hiding
+	now := time.Now()
+	if now.Sub(source.lastCheck) >= config.DefaultJwksFileCheck {
+		source.reloadErr = source.reload(now)
+		if source.reloadErr != nil {
+			log.Printf("Error reloading JWKS file %s, keeping last good keys: %s\n", source.path, source.reloadErr)
+		}
+	}
+
+	jwk, err := findStaticJwk(source.jwks, kid)
+	if err != nil && source.reloadErr != nil {
+		return nil, joinErrors(err, fmt.Errorf("reloading %s: %w", source.path, source.reloadErr))
+	}
+
+	return jwk, err
+}
+
+func (source *FileKeySource) reload(now time.Time) error {
+	source.lastCheck = now
+
+	info, err := os.Stat(source.path)
+	if err != nil {
+		return err
+	}
+
+	if source.jwks != nil && info.ModTime().Equal(source.modTime) && info.Size() == source.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(source.path)
+	if err != nil {
+		return err
+	}
+
+	jwks, err := ParseJwkSet(data)
+	if err != nil {
+		return err
+	}
+
+	source.jwks = jwks.Public()
+	source.modTime = info.ModTime()
+	source.size = info.Size()
+
+	return nil
+}
+
+type multiKeySource struct {
+	sources []KeySource
+}
+
+// KeySources combines sources, the key is taken from the first source
+// that has it
+func KeySources(sources ...KeySource) KeySource {
+	if len(sources) == 1 {
+		return sources[0]
+	}
+
+	return &multiKeySource{sources: sources}
+}
+
+// Key reports the errors of every source when none has the key, so an
+// unreachable source is not hidden by another not knowing the kid
+func (source *multiKeySource) Key(kid string) (*Jwk, error) {
+	errs := []error{}
+	for _, s := range source.sources {
+		jwk, err := s.Key(kid)
+		if err == nil {
+			return jwk, nil
+		}
+
+		if err != ErrUnknownKid {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil, ErrUnknownKid
+	}
+
+	return nil, joinErrors(errs...)
+}
+
+// findStaticJwk also accepts tokens without a kid when the set holds a
+// single key, as offline issuers often do not set one
+func findStaticJwk(jwks *JwkSet, kid string) (*Jwk, error) {
+	jwk := findJwk(jwks, kid)
+	if jwk == nil && kid == "" && jwks != nil && len(jwks.Keys) == 1 {
+		jwk = &jwks.Keys[0]
+	}
+
+	if jwk == nil {
+		return nil, ErrUnknownKid
+	}
+
+	return jwk, nil
+}
@@ -0,0 +1,191 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaticKeySource(t *testing.T) {
+	single := NewStaticKeySource(&JwkSet{Keys: []Jwk{{Kty: "EC", Kid: "kid1"}}})
+	multiple := NewStaticKeySource(&JwkSet{Keys: []Jwk{{Kty: "EC", Kid: "kid1"}, {Kty: "EC", Kid: "kid2"}}})
+
+	testCases := []struct {
+		name   string
+		source KeySource
+		kid    string
+		valid  bool
+	}{
+		{"known kid", multiple, "kid2", true},
+		{"unknown kid", multiple, "kid3", false},
+		{"no kid single key", single, "", true},
+		{"no kid multiple keys", multiple, "", false},
+		{"combined", KeySources(single, multiple), "kid2", true},
+		{"combined unknown kid", KeySources(single, multiple), "kid3", false},
+		{"no sources", KeySources(), "kid1", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			jwk, err := tc.source.Key(tc.kid)
+			if tc.valid != (err == nil) {
+				t.Fatalf(`Key(%q) = %+v, %v, want valid %t`, tc.kid, jwk, err, tc.valid)
+			}
+		})
+	}
+}
+
+func TestPinnedKeySource(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(key.Public())
+
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Headers: map[string]string{"kid": "pinned"}, Bytes: der})
+	pemData = append(pemData, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+
+	source, err := NewPinnedKeySource(pemData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk, _ := NewJwk(key.Public())
+	thumbprint, _ := jwk.Thumbprint(crypto.SHA256)
+
+	for _, kid := range []string{"pinned", thumbprint} {
+		got, err := source.Key(kid)
+		if err != nil || got.X != jwk.X {
+			t.Fatalf(`Key(%q) = %+v, %v, want match for pinned key, nil`, kid, got, err)
+		}
+	}
+
+	_, err = NewPinnedKeySource([]byte("no pem"))
+	if err == nil {
+		t.Fatalf(`NewPinnedKeySource(no pem) = _, nil, want error`)
+	}
+}
+
+func TestFileKeySource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJwks := func(kids ...string) {
+		jwks := JwkSet{Keys: []Jwk{}}
+		for _, kid := range kids {
+			jwks.Keys = append(jwks.Keys, Jwk{Kty: "EC", Kid: kid})
+		}
+
+		data, _ := json.Marshal(jwks)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeJwks("kid1")
+
+	source, err := NewFileKeySource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = source.Key("kid1")
+	if err != nil {
+		t.Fatalf(`Key("kid1") = _, %v, want match for nil`, err)
+	}
+
+	writeJwks("kid1", "rotated")
+	source.lastCheck = time.Time{}
+
+	_, err = source.Key("rotated")
+	if err != nil {
+		t.Fatalf(`Key("rotated") = _, %v, want match for nil`, err)
+	}
+
+	// a broken file keeps the last good set
+	os.WriteFile(path, []byte("broken"), 0600)
+	source.lastCheck = time.Time{}
+
+	_, err = source.Key("rotated")
+	if err != nil {
+		t.Fatalf(`Key("rotated") after broken file = _, %v, want match for nil`, err)
+	}
+
+	// an unknown kid reports the reload error along with it
+	_, err = source.Key("missing")
+	if !errors.Is(err, ErrUnknownKid) || !strings.Contains(err.Error(), "reloading") {
+		t.Fatalf(`Key("missing") after broken file = _, %v, want match for unknown kid and reload error`, err)
+	}
+}
+
+type failingKeySource struct {
+	err error
+}
+
+func (source *failingKeySource) Key(kid string) (*Jwk, error) {
+	return nil, source.err
+}
+
+func TestKeySourcesErrors(t *testing.T) {
+	unknown := NewStaticKeySource(&JwkSet{Keys: []Jwk{{Kty: "EC", Kid: "kid1"}, {Kty: "EC", Kid: "kid2"}}})
+	unavailable := &failingKeySource{err: verificationError(ErrKeyUnavailable, "jwks unreachable")}
+	broken := &failingKeySource{err: errors.New("broken source")}
+
+	testCases := []struct {
+		name   string
+		source KeySource
+		want   []error
+	}{
+		{"unknown kid", KeySources(unknown, unknown), []error{ErrUnknownKid}},
+		{"unavailable after unknown kid", KeySources(unknown, unavailable), []error{ErrKeyUnavailable}},
+		{"unavailable before unknown kid", KeySources(unavailable, unknown), []error{ErrKeyUnavailable}},
+		{"every failure", KeySources(broken, unavailable), []error{ErrKeyUnavailable}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.source.Key("kid3")
+			for _, want := range tc.want {
+				if !errors.Is(err, want) {
+					t.Fatalf(`Key() = _, %v, want match for %v`, err, want)
+				}
+			}
+		})
+	}
+
+	_, err := KeySources(broken, unavailable).Key("kid3")
+	if !strings.Contains(err.Error(), "broken source") || !strings.Contains(err.Error(), "jwks unreachable") {
+		t.Fatalf(`Key() = _, %v, want match for both errors`, err)
+	}
+}
+
+func TestVerifyCompactKeySource(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk, _ := NewJwk(key.Public())
+	jwk.Kid = "offline"
+
+	// the issuer is not reachable, keys must come from the source only
+	issuer := "https://offline.invalid/"
+	claims := map[string]interface{}{
+		"iss": issuer,
+		"aud": "audience",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	token := signCompact(t, key, "ES256", "offline", claims)
+	opts := VerifyOpts{
+		Issuer:   issuer,
+		Audience: "audience",
+		Keys:     NewStaticKeySource(&JwkSet{Keys: []Jwk{*jwk}}),
+	}
+
+	verified, err := VerifyCompact(token, opts)
+	if err != nil || verified.Iss != issuer {
+		t.Fatalf(`VerifyCompact() = %+v, %v, want match for claims, nil`, verified, err)
+	}
+}
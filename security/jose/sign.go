@@ -0,0 +1,124 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SigningKey is a private key used to produce JWS signatures
+type SigningKey struct {
+	Kid string
+	Alg string
+	Key crypto.Signer
+}
+
+// SignCompact returns the JWS Compact Serialization of the claims
+// signed with the key
+func SignCompact(claims *Claims, key *SigningKey, typ string) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	return signPayload(payload, key, typ)
+}
+
+func signPayload(payload []byte, key *SigningKey, typ string) (string, error) {
+	joseHeader := JoseHeader{
+		Alg: key.Alg,
+		Kid: key.Kid,
+		Typ: typ,
+	}
+
+	header, err := json.Marshal(joseHeader)
+	if err != nil {
+		return "", err
+	}
+
+	encoder := base64.RawURLEncoding.EncodeToString
+
+	input := fmt.Sprintf("%s.%s", encoder(header), encoder(payload))
+	signature, err := createSignature(key.Key, key.Alg, input)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s.%s", input, encoder(signature)), nil
+}
+
+func createSignature(key crypto.Signer, alg string, signingInput string) ([]byte, error) {
+	if alg == "EdDSA" {
+		edKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("not ed25519 private key")
+		}
+
+		return ed25519.Sign(edKey, []byte(signingInput)), nil
+	}
+
+	hash, err := fetchHash(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := hash.New()
+	hasher.Write([]byte(signingInput))
+	digest := hasher.Sum(nil)
+
+	switch alg {
+	case "RS256", "RS384", "RS512":
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("not rsa private key")
+		}
+
+		return rsa.SignPKCS1v15(rand.Reader, rsaKey, hash, digest)
+	case "PS256", "PS384", "PS512":
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("not rsa private key")
+		}
+
+		opts := &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash,
+			Hash:       hash,
+		}
+
+		return rsa.SignPSS(rand.Reader, rsaKey, hash, digest, opts)
+	case "ES256", "ES384", "ES512":
+		ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("not ecdsa private key")
+		}
+
+		curve, err := fetchCurve(alg)
+		if err != nil {
+			return nil, err
+		}
+
+		if ecdsaKey.Curve.Params().Name != curve.Params().Name {
+			return nil, errors.New("curve does not match algorithm")
+		}
+
+		r, s, err := ecdsa.Sign(rand.Reader, ecdsaKey, digest)
+		if err != nil {
+			return nil, err
+		}
+
+		size := (curve.Params().BitSize + 7) / 8
+		signature := make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+
+		return signature, nil
+	default:
+		return nil, errors.New("unsupported signing algorithm")
+	}
+}
@@ -0,0 +1,111 @@
+package jose
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignCompactRoundTrip(t *testing.T) {
+	for _, alg := range []string{"RS256", "PS256", "ES256", "ES384", "EdDSA"} {
+		t.Run(alg, func(t *testing.T) {
+			key, err := GenerateSigningKey(alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims := &Claims{Iss: "https://issuer/", Exp: 2}
+			token, err := SignCompact(claims, key, "JWT")
+			if err != nil {
+				t.Fatalf(`SignCompact(%q) = _, %v, want match for _, nil`, alg, err)
+			}
+
+			segments := strings.Split(token, ".")
+			if len(segments) != 3 {
+				t.Fatalf(`SignCompact(%q) = %q, want JWS compact`, alg, token)
+			}
+
+			signature, _ := base64.RawURLEncoding.DecodeString(segments[2])
+			input := segments[0] + "." + segments[1]
+			err = verifySignature(key.Key.Public(), alg, input, signature)
+			if err != nil {
+				t.Fatalf(`verifySignature(%q) = %v, want match for nil`, alg, err)
+			}
+		})
+	}
+}
+
+func TestSignCompactKeyMismatch(t *testing.T) {
+	key, err := GenerateSigningKey("ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key.Alg = "RS256"
+	_, err = SignCompact(&Claims{}, key, "JWT")
+	if err == nil {
+		t.Fatalf(`SignCompact(RS256 with ec key) = _, nil, want error`)
+	}
+}
+
+func TestClaimsMarshal(t *testing.T) {
+	claims := &Claims{
+		Iss:    "https://issuer/",
+		Aud:    []string{"audience"},
+		Exp:    2,
+		Custom: map[string]interface{}{"scope": "read:users"},
+	}
+
+	data, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed Claims
+	err = json.Unmarshal(data, &parsed)
+	if err != nil || parsed.Iss != claims.Iss || parsed.Aud[0] != "audience" || parsed.Exp != 2 {
+		t.Fatalf(`json.Unmarshal(%s) = %+v, %v, want match for %+v`, data, parsed, err, claims)
+	}
+
+	if strings.Contains(string(data), `"sub"`) {
+		t.Fatalf(`json.Marshal() = %s, want empty claims omitted`, data)
+	}
+}
+
+func TestVerifyCompactLocallySigned(t *testing.T) {
+	km, err := NewKeyManager(KeyManagerOpts{Alg: "ES256"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwks, _ := km.PublicJwkSet()
+		json.NewEncoder(w).Encode(jwks)
+	}))
+
+	defer ts.Close()
+
+	issuer := ts.URL + "/"
+	now := time.Now()
+	claims := &Claims{
+		Iss: issuer,
+		Sub: "subject",
+		Aud: []string{"audience"},
+		Exp: now.Add(time.Minute).Unix(),
+		Iat: now.Unix(),
+	}
+
+	token, err := km.Sign(claims, "JWT")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := VerifyCompact(token, VerifyOpts{Issuer: issuer, Audience: "audience"})
+	if err != nil || verified.Sub != "subject" {
+		t.Fatalf(`VerifyCompact() = %+v, %v, want match for subject, nil`, verified, err)
+	}
+}
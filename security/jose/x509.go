@@ -0,0 +1,217 @@
+package jose
+
+import (
+	"crypto"
+	_ "crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"dahbura.me/api/config"
+	"dahbura.me/api/util/cache"
+	httppkg "dahbura.me/api/util/http"
+)
+
+// CertificatePolicy validates the x5c certificates of signing keys
+type CertificatePolicy struct {
+	// Roots the x5c chain must verify against, the chain is not
+	// verified when nil
+	Roots *x509.CertPool
+	// RequireX5c refuses keys that do not publish a certificate
+	RequireX5c bool
+}
+
+// verifyJwkCertificate checks the x5c leaf certificate of a key against
+// the x5t and x5t#S256 thumbprints declared by the key and the token
+// header and, when a policy is given, its validity period and chain
+func verifyJwkCertificate(jwk *Jwk, header *JoseHeader, policy *CertificatePolicy, now time.Time) error {
+	if len(jwk.X5C) == 0 {
+		if policy != nil && policy.RequireX5c {
+			return verificationError(ErrInvalidCertificate, "x5c cert required")
+		}
+
+		return nil
+	}
+
+	leafDer, err := base64.StdEncoding.DecodeString(jwk.X5C[0])
+	if err != nil {
+		return verificationError(ErrInvalidCertificate, "unable to decode x5c certificate")
+	}
+
+	thumbprints := []struct {
+		declared string
+		hash     crypto.Hash
+	}{
+		{jwk.X5T, crypto.SHA1},
+		{jwk.X5TS256, crypto.SHA256},
+		{header.X5T, crypto.SHA1},
+		{header.X5TS256, crypto.SHA256},
+	}
+
+	for _, thumbprint := range thumbprints {
+		if thumbprint.declared == "" {
+			continue
+		}
+
+		if !matchesThumbprint(leafDer, thumbprint.declared, thumbprint.hash) {
+			return verificationError(ErrInvalidCertificate, "certificate thumbprint mismatch")
+		}
+	}
+
+	if policy == nil {
+		return nil
+	}
+
+	if policy.Roots != nil {
+		_, err := verifyCertificateChain(jwk.X5C, policy.Roots, now)
+		return err
+	}
+
+	leaf, err := x509.ParseCertificate(leafDer)
+	if err != nil {
+		return verificationError(ErrInvalidCertificate, "unable to parse x5c certificate")
+	}
+
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return verificationError(ErrInvalidCertificate, "certificate not valid at this time")
+	}
+
+	return nil
+}
+
+// CertificateThumbprint returns the x5t#S256 value of a DER encoded
+// certificate, as carried in "cnf" by certificate-bound tokens (RFC 8705)
+func CertificateThumbprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func matchesThumbprint(der []byte, declared string, hash crypto.Hash) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(declared, "="))
+	if err != nil {
+		return false
+	}
+
+	hasher := hash.New()
+	hasher.Write(der)
+
+	return subtle.ConstantTimeCompare(hasher.Sum(nil), decoded) == 1
+}
+
+// verifyCertificateChain parses an x5c chain (base64 DER, leaf first) and
+// verifies it against the trust anchors, returning the leaf certificate
+func verifyCertificateChain(x5c []string, roots *x509.CertPool, now time.Time) (*x509.Certificate, error) {
+	if len(x5c) == 0 {
+		return nil, verificationError(ErrInvalidCertificate, "empty certificate chain")
+	}
+
+	if roots == nil {
+		return nil, verificationError(ErrInvalidCertificate, "no trust anchors configured")
+	}
+
+	certs := make([]*x509.Certificate, len(x5c))
+	for i, encodedDer := range x5c {
+		decodedDer, err := base64.StdEncoding.DecodeString(encodedDer)
+		if err != nil {
+			return nil, verificationError(ErrInvalidCertificate, "unable to decode x5c certificate")
+		}
+
+		cert, err := x509.ParseCertificate(decodedDer)
+		if err != nil {
+			return nil, verificationError(ErrInvalidCertificate, "unable to parse x5c certificate")
+		}
+
+		certs[i] = cert
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+
+	if _, err := certs[0].Verify(opts); err != nil {
+		return nil, verificationError(ErrInvalidCertificate, "untrusted certificate chain: %v", err)
+	}
+
+	return certs[0], nil
+}
+
+// fetchCertificateChain reads the PEM encoded chain published at an x5u
+// URL and returns it in x5c form
+func fetchCertificateChain(x5u string) ([]string, error) {
+	memoryCache := cache.GetMemoryCache()
+
+	key := fmt.Sprintf("x5u:%s", x5u)
+	value, ok := memoryCache.Get(key)
+	if ok {
+		return value.([]string), nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, x5u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := httppkg.GetHttpClient()
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected x5u response status: %d", res.StatusCode)
+	}
+
+	pemData, err := io.ReadAll(io.LimitReader(res.Body, config.DefaultMaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+
+	x5c := []string{}
+	for {
+		var block *pem.Block
+		block, pemData = pem.Decode(pemData)
+		if block == nil {
+			break
+		}
+
+		if block.Type == "CERTIFICATE" {
+			x5c = append(x5c, base64.StdEncoding.EncodeToString(block.Bytes))
+		}
+	}
+
+	if len(x5c) == 0 {
+		return nil, errors.New("no certificates found at x5u")
+	}
+
+	item := cache.Item{
+		Key:   key,
+		Value: x5c,
+	}
+
+	itemPolicy := cache.ItemPolicy{
+		AbsoluteExp: time.Now().Add(config.DefaultJwksMaxAge),
+	}
+
+	memoryCache.Set(item, itemPolicy)
+
+	return x5c, nil
+}
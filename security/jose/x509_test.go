@@ -0,0 +1,154 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type testCertificateAuthority struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newTestCertificateAuthority(t *testing.T) *testCertificateAuthority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCertificateAuthority{key: key, cert: cert}
+}
+
+func (ca *testCertificateAuthority) issue(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	return ca.issueValid(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+}
+
+func (ca *testCertificateAuthority) issueValid(t *testing.T, notBefore time.Time, notAfter time.Time) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "Test Signing Key"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, der
+}
+
+func TestVerifyCertificateChain(t *testing.T) {
+	ca := newTestCertificateAuthority(t)
+	_, leafDer := ca.issue(t)
+	x5c := []string{base64.StdEncoding.EncodeToString(leafDer)}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	leaf, err := verifyCertificateChain(x5c, roots, time.Now())
+	if err != nil || leaf.Subject.CommonName != "Test Signing Key" {
+		t.Fatalf(`verifyCertificateChain() = %v, %v, want match for leaf, nil`, leaf, err)
+	}
+
+	_, err = verifyCertificateChain(x5c, nil, time.Now())
+	if err == nil {
+		t.Fatalf(`verifyCertificateChain(no roots) = _, nil, want error`)
+	}
+
+	_, err = verifyCertificateChain(x5c, x509.NewCertPool(), time.Now())
+	if err == nil {
+		t.Fatalf(`verifyCertificateChain(empty roots) = _, nil, want error`)
+	}
+}
+
+func TestVerifyJwkCertificate(t *testing.T) {
+	ca := newTestCertificateAuthority(t)
+	_, leafDer := ca.issue(t)
+	_, expiredDer := ca.issueValid(t, time.Now().Add(-time.Hour*2), time.Now().Add(-time.Hour))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	sha1Sum := sha1.Sum(leafDer)
+	sha256Sum := sha256.Sum256(leafDer)
+	x5t := base64.RawURLEncoding.EncodeToString(sha1Sum[:])
+	x5tS256 := base64.RawURLEncoding.EncodeToString(sha256Sum[:])
+	x5c := []string{base64.StdEncoding.EncodeToString(leafDer)}
+	expiredX5c := []string{base64.StdEncoding.EncodeToString(expiredDer)}
+
+	tests := []struct {
+		name    string
+		jwk     Jwk
+		header  JoseHeader
+		policy  *CertificatePolicy
+		wantErr bool
+	}{
+		{"no x5c", Jwk{}, JoseHeader{}, nil, false},
+		{"no x5c required", Jwk{}, JoseHeader{}, &CertificatePolicy{RequireX5c: true}, true},
+		{"thumbprints", Jwk{X5C: x5c, X5T: x5t, X5TS256: x5tS256}, JoseHeader{X5TS256: x5tS256}, nil, false},
+		{"x5t mismatch", Jwk{X5C: x5c, X5T: x5tS256}, JoseHeader{}, nil, true},
+		{"header x5t#S256 mismatch", Jwk{X5C: x5c}, JoseHeader{X5TS256: x5t}, nil, true},
+		{"chain", Jwk{X5C: x5c}, JoseHeader{}, &CertificatePolicy{Roots: roots}, false},
+		{"chain untrusted", Jwk{X5C: x5c}, JoseHeader{}, &CertificatePolicy{Roots: x509.NewCertPool()}, true},
+		{"expired", Jwk{X5C: expiredX5c}, JoseHeader{}, &CertificatePolicy{}, true},
+		{"expired ignored", Jwk{X5C: expiredX5c}, JoseHeader{}, nil, false},
+	}
+
+	for _, test := range tests {
+		err := verifyJwkCertificate(&test.jwk, &test.header, test.policy, time.Now())
+		if (err != nil) != test.wantErr {
+			t.Fatalf(`verifyJwkCertificate(%s) = %v, want error %v`, test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestCertificateThumbprint(t *testing.T) {
+	ca := newTestCertificateAuthority(t)
+	_, leafDer := ca.issue(t)
+
+	sum := sha256.Sum256(leafDer)
+	want := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	got := CertificateThumbprint(leafDer)
+	if got != want || !matchesThumbprint(leafDer, got, crypto.SHA256) {
+		t.Fatalf(`CertificateThumbprint() = %q, want match for %q`, got, want)
+	}
+}
@@ -0,0 +1,185 @@
+package introspection
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"dahbura.me/api/config"
+	"dahbura.me/api/security/jose"
+	"dahbura.me/api/util/cache"
+	httppkg "dahbura.me/api/util/http"
+)
+
+// ErrUnavailable is returned when the introspection endpoint cannot be
+// reached or does not answer as RFC 7662 describes, the token itself may
+// be valid
+var ErrUnavailable = errors.New("introspection temporarily unavailable")
+
+// Introspector resolves opaque access tokens through an RFC 7662 token
+// introspection endpoint, active results are cached until they expire
+type Introspector struct {
+	opts  IntrospectorOpts
+	cache *cache.MemoryCache
+	now   func() time.Time
+}
+
+type IntrospectorOpts struct {
+	Url          string
+	ClientId     string
+	ClientSecret string
+
+	// Audience the token must be issued for, not checked when empty
+	Audience string
+
+	// Leeway is the clock skew tolerated when comparing exp and nbf
+	Leeway time.Duration
+}
+
+type introspectionResponse struct {
+	Active bool `json:"active"`
+}
+
+func NewIntrospector(opts IntrospectorOpts) (*Introspector, error) {
+	_, err := url.ParseRequestURI(opts.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.ClientId == "" {
+		return nil, errors.New("clientId required")
+	}
+
+	if opts.ClientSecret == "" {
+		return nil, errors.New("clientSecret required")
+	}
+
+	introspector := Introspector{
+		opts:  opts,
+		cache: cache.New(),
+		now:   time.Now,
+	}
+
+	return &introspector, nil
+}
+
+// Introspect returns the claims of an active token, the response members
+// (scope, client_id, username, ...) are kept as custom claims
+func (in *Introspector) Introspect(token string) (*jose.Claims, error) {
+	key := cacheKey(token)
+
+	cached, ok := in.cache.Get(key)
+	if ok {
+		return cached.(*jose.Claims), nil
+	}
+
+	claims, err := in.do(token)
+	if err != nil {
+		return nil, err
+	}
+
+	err = in.validate(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Exp != 0 {
+		item := cache.Item{Key: key, Value: claims}
+		itemPolicy := cache.ItemPolicy{AbsoluteExp: claims.ExpirationTime()}
+		in.cache.Set(item, itemPolicy)
+	}
+
+	return claims, nil
+}
+
+func (in *Introspector) do(token string) (*jose.Claims, error) {
+	values := url.Values{}
+	values.Set("token", token)
+	values.Set("token_type_hint", "access_token")
+
+	req, err := http.NewRequest(http.MethodPost, in.opts.Url, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", config.MimeApplicationJson)
+	req.Header.Set("Content-Type", config.MimeApplicationXWwwFormUrlencoded)
+	req.SetBasicAuth(url.QueryEscape(in.opts.ClientId), url.QueryEscape(in.opts.ClientSecret))
+
+	httpClient := httppkg.GetHttpClient()
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected response status %d", ErrUnavailable, res.StatusCode)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	var inres introspectionResponse
+	if err := json.Unmarshal(body, &inres); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	if !inres.Active {
+		return nil, errors.New("inactive token")
+	}
+
+	var claims jose.Claims
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	delete(claims.Custom, "active")
+
+	return &claims, nil
+}
+
+func (in *Introspector) validate(claims *jose.Claims) error {
+	now := in.now()
+
+	if claims.Exp != 0 && !now.Before(claims.ExpirationTime().Add(in.opts.Leeway)) {
+		return errors.New("token expired")
+	}
+
+	if claims.Nbf != 0 && now.Add(in.opts.Leeway).Before(claims.NotBefore()) {
+		return errors.New("token not yet valid")
+	}
+
+	if in.opts.Audience != "" && !hasAudience(claims.Aud, in.opts.Audience) {
+		return errors.New("invalid audience")
+	}
+
+	return nil
+}
+
+// cacheKey avoids keeping the raw bearer tokens in memory
+func cacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func hasAudience(data []string, aud string) bool {
+	for _, v := range data {
+		if v == aud {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,102 @@
+package introspection
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestIntrospector(t *testing.T, handler http.HandlerFunc) *Introspector {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	opts := IntrospectorOpts{
+		Url:          ts.URL,
+		ClientId:     "client",
+		ClientSecret: "secret",
+		Audience:     "https://api",
+	}
+
+	introspector, err := NewIntrospector(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return introspector
+}
+
+func TestIntrospect(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	calls := 0
+
+	introspector := newTestIntrospector(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		clientId, clientSecret, ok := r.BasicAuth()
+		if !ok || clientId != "client" || clientSecret != "secret" || r.PostFormValue("token") != "opaque" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		fmt.Fprintf(w, `{"active":true,"scope":"read:users update:users","client_id":"c1","sub":"u1","aud":"https://api","exp":%d}`, exp)
+	})
+
+	for i := 0; i < 2; i++ {
+		claims, err := introspector.Introspect("opaque")
+		if err != nil || claims.Sub != "u1" || claims.Exp != exp {
+			t.Fatalf(`Introspect() = %+v, %v, want match for sub u1, nil`, claims, err)
+		}
+
+		scopes, ok := claims.Strings("scope")
+		if !ok || len(scopes) != 2 || scopes[1] != "update:users" {
+			t.Fatalf(`Strings("scope") = %v, %v, want match for 2 scopes`, scopes, ok)
+		}
+
+		clientId, ok := claims.String("client_id")
+		if !ok || clientId != "c1" || claims.Has("active") {
+			t.Fatalf(`String("client_id") = %v, %v, want match for c1`, clientId, ok)
+		}
+	}
+
+	if calls != 1 {
+		t.Fatalf(`introspection calls = %d, want match for 1`, calls)
+	}
+}
+
+func TestIntrospectRejected(t *testing.T) {
+	expired := time.Now().Add(-time.Hour).Unix()
+	exp := time.Now().Add(time.Hour).Unix()
+
+	testCases := []struct {
+		name        string
+		response    string
+		status      int
+		unavailable bool
+	}{
+		{"inactive", `{"active":false}`, http.StatusOK, false},
+		{"expired", fmt.Sprintf(`{"active":true,"aud":"https://api","exp":%d}`, expired), http.StatusOK, false},
+		{"wrong audience", fmt.Sprintf(`{"active":true,"aud":"https://other","exp":%d}`, exp), http.StatusOK, false},
+		{"error status", `{}`, http.StatusUnauthorized, true},
+		{"server error", `{}`, http.StatusInternalServerError, true},
+		{"malformed response", `not json`, http.StatusOK, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			introspector := newTestIntrospector(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.response)
+			})
+
+			claims, err := introspector.Introspect("opaque")
+			if err == nil {
+				t.Fatalf(`Introspect() = %+v, nil, want error`, claims)
+			}
+			if tc.unavailable != errors.Is(err, ErrUnavailable) {
+				t.Fatalf(`Introspect() = _, %v, want unavailable %t`, err, tc.unavailable)
+			}
+		})
+	}
+}
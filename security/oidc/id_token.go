@@ -0,0 +1,177 @@
+package oidc
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"time"
+
+	"dahbura.me/api/security/jose"
+)
+
+// ID token failures not covered by the jose errors, test for them with
+// errors.Is
+var (
+	ErrInvalidNonce           = errors.New("invalid nonce")
+	ErrInvalidAuthorizedParty = errors.New("invalid authorized party")
+	ErrInvalidTokenHash       = errors.New("invalid token hash")
+	ErrAuthenticationTooOld   = errors.New("authentication exceeds maximum age")
+	ErrInvalidAcr             = errors.New("authentication context not accepted")
+)
+
+// idTokenClaims are required by OpenID Connect Core section 2
+var idTokenClaims = []string{"sub", "iat"}
+
+type IdTokenOpts struct {
+	Issuer   string
+	ClientId string
+
+	// Nonce sent in the authentication request, the token must carry it
+	Nonce string
+
+	// AccessToken and Code returned with the ID token are checked against
+	// the at_hash and c_hash claims
+	AccessToken string
+	Code        string
+
+	// RequireHashes rejects tokens without at_hash or c_hash when
+	// AccessToken or Code is set, as front channel responses require
+	RequireHashes bool
+
+	// MaxAge requested in the authentication request, auth_time is then
+	// required (0 disables)
+	MaxAge time.Duration
+
+	// Acr values accepted for the authentication (any when empty)
+	Acr []string
+
+	// Leeway is the clock skew tolerated when comparing times
+	Leeway time.Duration
+
+	// Algorithms allowed for the token, jose.DefaultAlgorithms when empty
+	Algorithms []string
+}
+
+// VerifyIdToken verifies the signature of the ID token with the keys of
+// the discovered jwks_uri of the issuer and validates its claims
+func VerifyIdToken(token string, opts IdTokenOpts) (*jose.Claims, error) {
+	providerConfig, err := GetOpenIdProviderConfig(opts.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	verifyOpts := jose.VerifyOpts{
+		Issuer:         opts.Issuer,
+		Audience:       opts.ClientId,
+		JwksUrl:        providerConfig.JwksUri,
+		Leeway:         opts.Leeway,
+		RequiredClaims: idTokenClaims,
+		Algorithms:     opts.Algorithms,
+	}
+
+	claims, err := jose.VerifyCompact(token, verifyOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	// the header is part of the signing input, it was verified above
+	header, err := jose.UnverifiedHeader(token)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateIdTokenClaims(claims, header.Alg, opts, now())
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// validateIdTokenClaims follows OpenID Connect Core sections 3.1.3.7,
+// 3.2.2.11 and 3.3.2.11
+func validateIdTokenClaims(claims *jose.Claims, alg string, opts IdTokenOpts, now time.Time) error {
+	azp, hasAzp := claims.String("azp")
+	if len(claims.Aud) > 1 && !hasAzp {
+		return missingClaim("azp")
+	}
+
+	if hasAzp && azp != opts.ClientId {
+		return ErrInvalidAuthorizedParty
+	}
+
+	if opts.Nonce != "" {
+		nonce, ok := claims.String("nonce")
+		if !ok {
+			return missingClaim("nonce")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(nonce), []byte(opts.Nonce)) != 1 {
+			return ErrInvalidNonce
+		}
+	}
+
+	err := validateTokenHash(claims, "at_hash", opts.AccessToken, alg, opts.RequireHashes)
+	if err != nil {
+		return err
+	}
+
+	err = validateTokenHash(claims, "c_hash", opts.Code, alg, opts.RequireHashes)
+	if err != nil {
+		return err
+	}
+
+	if opts.MaxAge > 0 {
+		authTime, ok := claims.Time("auth_time")
+		if !ok {
+			return missingClaim("auth_time")
+		}
+
+		if now.Sub(authTime) > opts.MaxAge+opts.Leeway {
+			return ErrAuthenticationTooOld
+		}
+	}
+
+	if len(opts.Acr) > 0 {
+		acr, ok := claims.String("acr")
+		if !ok {
+			return missingClaim("acr")
+		}
+
+		if !jose.Contains(opts.Acr, acr) {
+			return fmt.Errorf("%w: %s", ErrInvalidAcr, acr)
+		}
+	}
+
+	return nil
+}
+
+func validateTokenHash(claims *jose.Claims, name string, value string, alg string, required bool) error {
+	if value == "" {
+		return nil
+	}
+
+	declared, ok := claims.String(name)
+	if !ok {
+		if required {
+			return missingClaim(name)
+		}
+
+		return nil
+	}
+
+	hash, err := jose.TokenHash(value, alg)
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(declared)) != 1 {
+		return fmt.Errorf("%w: %s", ErrInvalidTokenHash, name)
+	}
+
+	return nil
+}
+
+func missingClaim(name string) error {
+	return &jose.VerificationError{Err: jose.ErrMissingClaim, Claim: name, Detail: name}
+}
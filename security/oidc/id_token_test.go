@@ -0,0 +1,175 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dahbura.me/api/security/jose"
+)
+
+func TestVerifyIdToken(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk, _ := jose.NewJwk(key.Public())
+	jwk.Kid = "kid1"
+
+	var issuer string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer": %q, "jwks_uri": "%sdiscovered/keys"}`, issuer, issuer)
+		case "/discovered/keys":
+			json.NewEncoder(w).Encode(jose.JwkSet{Keys: []jose.Jwk{*jwk}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	defer ts.Close()
+
+	issuer = ts.URL + "/"
+
+	now := time.Now()
+	claims := jose.Claims{
+		Iss: issuer,
+		Sub: "subject",
+		Aud: []string{"client"},
+		Exp: now.Add(time.Hour).Unix(),
+		Iat: now.Unix(),
+		Custom: map[string]interface{}{
+			"nonce": "n-0S6_WzA2Mj",
+		},
+	}
+
+	token, err := jose.SignCompact(&claims, &jose.SigningKey{Kid: "kid1", Alg: "ES256", Key: key}, "JWT")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name  string
+		opts  IdTokenOpts
+		valid bool
+	}{
+		{"valid", IdTokenOpts{Issuer: issuer, ClientId: "client", Nonce: "n-0S6_WzA2Mj"}, true},
+		{"wrong nonce", IdTokenOpts{Issuer: issuer, ClientId: "client", Nonce: "other"}, false},
+		{"wrong client", IdTokenOpts{Issuer: issuer, ClientId: "other"}, false},
+		{"algorithm not allowed", IdTokenOpts{Issuer: issuer, ClientId: "client", Algorithms: []string{"RS256"}}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := VerifyIdToken(token, tc.opts)
+			if tc.valid && (err != nil || got.Sub != "subject") {
+				t.Fatalf(`VerifyIdToken() = %+v, %v, want match for claims, nil`, got, err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatalf(`VerifyIdToken() = %+v, nil, want error`, got)
+			}
+		})
+	}
+}
+
+func TestValidateIdTokenClaims(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	// OpenID Connect Core appendix A.4
+	accessToken := "jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y"
+	atHash := "77QmUPtjPfzWtF2AnpK9RQ"
+	code := "Qcb0Orv1zh30vL1MPRsbm-diHiMwcLyZvn1arpZv-Jxf_11jnpEX3Tgfvk"
+	cHash := "LDktKdoQak3Pk0cnXxCltA"
+
+	valid := func() jose.Claims {
+		return jose.Claims{
+			Iss: "https://issuer/",
+			Sub: "subject",
+			Aud: []string{"client"},
+			Custom: map[string]interface{}{
+				"nonce":     "nonce",
+				"auth_time": float64(now.Add(-time.Minute).Unix()),
+				"acr":       "urn:mace:incommon:iap:silver",
+			},
+		}
+	}
+
+	opts := IdTokenOpts{
+		ClientId: "client",
+		Nonce:    "nonce",
+		Leeway:   time.Second * 30,
+	}
+
+	testCases := []struct {
+		name   string
+		mutate func(*jose.Claims, *IdTokenOpts)
+		want   error
+	}{
+		{"valid", func(c *jose.Claims, o *IdTokenOpts) {}, nil},
+		{"missing nonce", func(c *jose.Claims, o *IdTokenOpts) { delete(c.Custom, "nonce") }, jose.ErrMissingClaim},
+		{"wrong nonce", func(c *jose.Claims, o *IdTokenOpts) { c.Custom["nonce"] = "other" }, ErrInvalidNonce},
+		{"nonce not requested", func(c *jose.Claims, o *IdTokenOpts) { o.Nonce = "" }, nil},
+		{"multiple audiences with azp", func(c *jose.Claims, o *IdTokenOpts) {
+			c.Aud = []string{"client", "api"}
+			c.Custom["azp"] = "client"
+		}, nil},
+		{"multiple audiences without azp", func(c *jose.Claims, o *IdTokenOpts) { c.Aud = []string{"client", "api"} }, jose.ErrMissingClaim},
+		{"azp of other client", func(c *jose.Claims, o *IdTokenOpts) { c.Custom["azp"] = "other" }, ErrInvalidAuthorizedParty},
+		{"at_hash", func(c *jose.Claims, o *IdTokenOpts) {
+			c.Custom["at_hash"] = atHash
+			o.AccessToken = accessToken
+		}, nil},
+		{"wrong at_hash", func(c *jose.Claims, o *IdTokenOpts) {
+			c.Custom["at_hash"] = cHash
+			o.AccessToken = accessToken
+		}, ErrInvalidTokenHash},
+		{"missing at_hash", func(c *jose.Claims, o *IdTokenOpts) { o.AccessToken = accessToken }, nil},
+		{"missing required at_hash", func(c *jose.Claims, o *IdTokenOpts) {
+			o.AccessToken = accessToken
+			o.RequireHashes = true
+		}, jose.ErrMissingClaim},
+		{"c_hash", func(c *jose.Claims, o *IdTokenOpts) {
+			c.Custom["c_hash"] = cHash
+			o.Code = code
+			o.RequireHashes = true
+		}, nil},
+		{"wrong c_hash", func(c *jose.Claims, o *IdTokenOpts) {
+			c.Custom["c_hash"] = atHash
+			o.Code = code
+		}, ErrInvalidTokenHash},
+		{"max age", func(c *jose.Claims, o *IdTokenOpts) { o.MaxAge = time.Minute * 5 }, nil},
+		{"max age exceeded", func(c *jose.Claims, o *IdTokenOpts) { o.MaxAge = time.Second * 10 }, ErrAuthenticationTooOld},
+		{"max age missing auth_time", func(c *jose.Claims, o *IdTokenOpts) {
+			delete(c.Custom, "auth_time")
+			o.MaxAge = time.Hour
+		}, jose.ErrMissingClaim},
+		{"acr", func(c *jose.Claims, o *IdTokenOpts) { o.Acr = []string{"urn:mace:incommon:iap:silver"} }, nil},
+		{"acr not accepted", func(c *jose.Claims, o *IdTokenOpts) { o.Acr = []string{"urn:mace:incommon:iap:gold"} }, ErrInvalidAcr},
+		{"missing acr", func(c *jose.Claims, o *IdTokenOpts) {
+			delete(c.Custom, "acr")
+			o.Acr = []string{"urn:mace:incommon:iap:silver"}
+		}, jose.ErrMissingClaim},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := valid()
+			tcOpts := opts
+			tc.mutate(&claims, &tcOpts)
+
+			err := validateIdTokenClaims(&claims, "RS256", tcOpts, now)
+			if tc.want == nil && err != nil || tc.want != nil && !errors.Is(err, tc.want) {
+				t.Fatalf(`validateIdTokenClaims() = %v, want match for %v`, err, tc.want)
+			}
+		})
+	}
+}
@@ -2,10 +2,16 @@ package oidc
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"time"
 
+	"dahbura.me/api/config"
 	httppkg "dahbura.me/api/util/http"
 )
 
@@ -30,15 +36,85 @@ type OpenIdProviderConfig struct {
 	RequestURIParameterSupported      bool     `json:"request_uri_parameter_supported"`
 }
 
+var (
+	providers    = map[string]*providerEntry{}
+	providersMtx = sync.Mutex{}
+	now          = time.Now
+)
+
+type providerEntry struct {
+	mtx         sync.Mutex
+	config      *OpenIdProviderConfig
+	expiresAt   time.Time
+	lastAttempt time.Time
+}
+
+// GetOpenIdProviderConfig returns the cached discovery document of the
+// issuer, reading it again once the cached copy expires. While the issuer
+// is unreachable the last good document is served and discovery is only
+// retried every DefaultDiscoveryMinRetry
+func GetOpenIdProviderConfig(issuer string) (*OpenIdProviderConfig, error) {
+	entry := providerEntryFor(issuer)
+
+	entry.mtx.Lock()
+	defer entry.mtx.Unlock()
+
+	t := now()
+
+	if entry.config != nil && t.Before(entry.expiresAt) {
+		return entry.config, nil
+	}
+
+	if !entry.lastAttempt.IsZero() && t.Sub(entry.lastAttempt) < config.DefaultDiscoveryMinRetry {
+		if entry.config != nil {
+			return entry.config, nil
+		}
+
+		return nil, errors.New("discovery temporarily unavailable")
+	}
+
+	entry.lastAttempt = t
+
+	providerConfig, err := ReadOpenIdProviderConfig(issuer)
+	if err != nil {
+		if entry.config != nil {
+			log.Printf("Error reading discovery document of %s, serving last good copy: %s\n", issuer, err)
+			return entry.config, nil
+		}
+
+		return nil, err
+	}
+
+	entry.config = providerConfig
+	entry.expiresAt = t.Add(config.DefaultDiscoveryMaxAge)
+
+	return providerConfig, nil
+}
+
+func providerEntryFor(issuer string) *providerEntry {
+	providersMtx.Lock()
+	defer providersMtx.Unlock()
+
+	entry, ok := providers[issuer]
+	if !ok {
+		entry = &providerEntry{}
+		providers[issuer] = entry
+	}
+
+	return entry
+}
+
+// ReadOpenIdProviderConfig reads the discovery document of the issuer
+// and verifies that it was published for that issuer
 func ReadOpenIdProviderConfig(issuer string) (*OpenIdProviderConfig, error) {
 	_, err := url.ParseRequestURI(issuer)
 	if err != nil {
 		return nil, err
 	}
 
-	url := fmt.Sprintf("%s/.well-known/openid-configuration", issuer)
+	configUrl := fmt.Sprintf("%s/.well-known/openid-configuration", strings.TrimSuffix(issuer, "/"))
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequest(http.MethodGet, configUrl, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -52,13 +128,25 @@ func ReadOpenIdProviderConfig(issuer string) (*OpenIdProviderConfig, error) {
 
 	defer res.Body.Close()
 
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected discovery response status: %d", res.StatusCode)
+	}
+
 	decoder := json.NewDecoder(res.Body)
-	decoder.DisallowUnknownFields()
 
 	var config OpenIdProviderConfig
 	if err := decoder.Decode(&config); err != nil {
 		return nil, err
 	}
 
+	if config.Issuer != issuer {
+		return nil, errors.New("discovery issuer does not match issuer")
+	}
+
+	_, err = url.ParseRequestURI(config.JwksUri)
+	if err != nil {
+		return nil, errors.New("improperly formatted jwks_uri")
+	}
+
 	return &config, nil
 }
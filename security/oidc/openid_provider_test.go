@@ -0,0 +1,150 @@
+package oidc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dahbura.me/api/config"
+)
+
+func TestReadOpenIdProviderConfig(t *testing.T) {
+	var issuer string
+	var published string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/openid-configuration" {
+			http.NotFound(w, r)
+			return
+		}
+
+		fmt.Fprintf(w, `{
+			"issuer": %q,
+			"jwks_uri": "%skeys",
+			"end_session_endpoint": "https://example.com/logout"
+		}`, published, issuer)
+	}))
+
+	defer ts.Close()
+
+	issuer = ts.URL + "/"
+
+	testCases := []struct {
+		name      string
+		published string
+		valid     bool
+	}{
+		{"matching issuer", issuer, true},
+		{"mismatched issuer", "https://attacker.example.com/", false},
+	}
+
+	for _, tc := range testCases {
+		published = tc.published
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := ReadOpenIdProviderConfig(issuer)
+			if tc.valid && (err != nil || config.JwksUri != issuer+"keys") {
+				t.Fatalf(`ReadOpenIdProviderConfig() = %+v, %v, want match for jwks_uri, nil`, config, err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatalf(`ReadOpenIdProviderConfig() = %+v, nil, want error`, config)
+			}
+		})
+	}
+}
+
+func TestGetOpenIdProviderConfigCached(t *testing.T) {
+	requests := 0
+	var issuer string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintf(w, `{"issuer": %q, "jwks_uri": "%skeys"}`, issuer, issuer)
+	}))
+
+	defer ts.Close()
+
+	issuer = ts.URL + "/"
+
+	for i := 0; i < 3; i++ {
+		_, err := GetOpenIdProviderConfig(issuer)
+		if err != nil {
+			t.Fatalf(`GetOpenIdProviderConfig() = _, %v, want match for _, nil`, err)
+		}
+	}
+
+	if requests != 1 {
+		t.Fatalf(`requests = %d, want match for 1`, requests)
+	}
+}
+
+func TestGetOpenIdProviderConfigStaleIfError(t *testing.T) {
+	requests := 0
+	failing := false
+	var issuer string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `{"issuer": %q, "jwks_uri": "%skeys"}`, issuer, issuer)
+	}))
+
+	defer ts.Close()
+
+	issuer = ts.URL + "/"
+
+	clock := time.Now()
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	get := func(step string, wantRequests int) {
+		providerConfig, err := GetOpenIdProviderConfig(issuer)
+		if err != nil || providerConfig.JwksUri != issuer+"keys" {
+			t.Fatalf(`GetOpenIdProviderConfig(%s) = %+v, %v, want match for jwks_uri, nil`, step, providerConfig, err)
+		}
+		if requests != wantRequests {
+			t.Fatalf(`requests(%s) = %d, want match for %d`, step, requests, wantRequests)
+		}
+	}
+
+	get("first", 1)
+
+	failing = true
+	clock = clock.Add(config.DefaultDiscoveryMaxAge)
+	get("expired while failing", 2)
+
+	clock = clock.Add(config.DefaultDiscoveryMinRetry / 2)
+	get("retry rate limited", 2)
+
+	clock = clock.Add(config.DefaultDiscoveryMinRetry)
+	get("retry", 3)
+
+	failing = false
+	clock = clock.Add(config.DefaultDiscoveryMinRetry)
+	get("recovered", 4)
+	get("cached after recovery", 4)
+}
+
+func TestGetOpenIdProviderConfigUnavailable(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	defer ts.Close()
+
+	issuer := ts.URL + "/"
+
+	for i := 0; i < 3; i++ {
+		_, err := GetOpenIdProviderConfig(issuer)
+		if err == nil {
+			t.Fatalf(`GetOpenIdProviderConfig() = _, nil, want error`)
+		}
+	}
+
+	if requests != 1 {
+		t.Fatalf(`requests = %d, want match for 1`, requests)
+	}
+}
@@ -0,0 +1,277 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"dahbura.me/api/config"
+	"dahbura.me/api/database/models"
+	"dahbura.me/api/database/mongodb"
+	"dahbura.me/api/security/jose"
+	"dahbura.me/api/util/cache"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const collectionName = "revocations"
+
+var ErrRevoked = errors.New("token revoked")
+
+var (
+	store     *Store
+	storeOnce sync.Once
+)
+
+// Store is the token deny-list, persisted in Mongo and mirrored in
+// memory so checking a token never waits on the database
+type Store struct {
+	opts      StoreOpts
+	cache     *cache.MemoryCache
+	mtx       sync.Mutex
+	indexOnce sync.Once
+}
+
+type StoreOpts struct {
+	// Leeway tolerated on the exp of the revoked tokens, revocations are
+	// kept that much longer
+	Leeway time.Duration
+	// RefreshInterval at which revocations added by other instances are
+	// loaded from Mongo
+	RefreshInterval time.Duration
+}
+
+func GetStore() *Store {
+	storeOnce.Do(initStore)
+
+	return store
+}
+
+func initStore() {
+	opts := StoreOpts{
+		Leeway:          config.TokenLeeway,
+		RefreshInterval: config.DefaultRevocationRefresh,
+	}
+
+	store = NewStore(opts)
+
+	go store.startRefresher()
+}
+
+func NewStore(opts StoreOpts) *Store {
+	s := Store{
+		opts:  opts,
+		cache: cache.New(),
+		mtx:   sync.Mutex{},
+	}
+
+	return &s
+}
+
+// IsRevoked reports whether the token is denied by its jti or by a
+// subject revocation issued after the token
+func (s *Store) IsRevoked(claims *jose.Claims) bool {
+	if claims.Jti != "" {
+		for _, key := range []string{jtiKey("", claims.Jti), jtiKey(claims.Iss, claims.Jti)} {
+			if _, ok := s.cache.Get(key); ok {
+				return true
+			}
+		}
+	}
+
+	if claims.Sub != "" {
+		for _, key := range []string{subKey("", claims.Sub), subKey(claims.Iss, claims.Sub)} {
+			value, ok := s.cache.Get(key)
+			if !ok {
+				continue
+			}
+
+			// a token without iat cannot prove it was issued after the cutoff
+			rev := value.(models.Revocation)
+			if claims.Iat == 0 || claims.IssuedAt().Before(rev.IssuedBefore) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Add persists the revocation and applies it immediately on this instance
+func (s *Store) Add(rev *models.Revocation) error {
+	err := s.complete(rev, time.Now())
+	if err != nil {
+		return err
+	}
+
+	db, err := mongodb.GetDatabase()
+	if err != nil {
+		return err
+	}
+
+	s.ensureIndexes(db)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.DefaultCtxTimeout)
+	defer cancel()
+
+	result, err := db.Collection(collectionName).InsertOne(ctx, rev)
+	if err != nil {
+		return err
+	}
+
+	id, _ := result.InsertedID.(primitive.ObjectID)
+	rev.Id = id
+
+	s.remember(*rev)
+
+	return nil
+}
+
+// List returns the revocations that have not expired yet
+func (s *Store) List() ([]models.Revocation, error) {
+	db, err := mongodb.GetDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	s.ensureIndexes(db)
+
+	filter := bson.M{"expires_at": bson.M{"$gt": time.Now().Add(-s.opts.Leeway)}}
+	opts := options.FindOptions{
+		Sort: bson.M{"created_at": -1},
+	}
+
+	ctxFind, cancelFind := context.WithTimeout(context.Background(), config.DefaultCtxTimeout)
+	defer cancelFind()
+
+	cursor, err := db.Collection(collectionName).Find(ctxFind, filter, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctxCursor, cancelCursor := context.WithTimeout(context.Background(), config.DefaultCtxTimeout)
+	defer cancelCursor()
+
+	revs := []models.Revocation{}
+	err = cursor.All(ctxCursor, &revs)
+	if err != nil {
+		return nil, err
+	}
+
+	return revs, nil
+}
+
+// Refresh loads the revocations from Mongo into memory
+func (s *Store) Refresh() error {
+	revs, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	for _, rev := range revs {
+		s.remember(rev)
+	}
+
+	return nil
+}
+
+// complete fills in the subject cutoff, the expiry must be the exp of the
+// revoked tokens so a revocation never lapses while they are still valid
+func (s *Store) complete(rev *models.Revocation, now time.Time) error {
+	if rev.Jti == "" && rev.Sub == "" {
+		return errors.New("jti or sub required")
+	}
+
+	if rev.Sub != "" && rev.IssuedBefore.IsZero() {
+		rev.IssuedBefore = now
+	}
+
+	if rev.ExpiresAt.IsZero() {
+		return errors.New("expires_at required")
+	}
+
+	if !rev.ExpiresAt.Add(s.opts.Leeway).After(now) {
+		return errors.New("revocation already expired")
+	}
+
+	rev.CreatedAt = now
+
+	return nil
+}
+
+func (s *Store) remember(rev models.Revocation) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	itemPolicy := cache.ItemPolicy{AbsoluteExp: s.expiry(rev)}
+
+	if rev.Jti != "" {
+		s.cache.Set(cache.Item{Key: jtiKey(rev.Iss, rev.Jti), Value: rev}, itemPolicy)
+	}
+
+	if rev.Sub != "" {
+		key := subKey(rev.Iss, rev.Sub)
+
+		// the latest cutoff covers every earlier one for the subject
+		value, ok := s.cache.Get(key)
+		if ok {
+			existing := value.(models.Revocation)
+			if existing.IssuedBefore.After(rev.IssuedBefore) {
+				rev.IssuedBefore = existing.IssuedBefore
+			}
+			if existing.ExpiresAt.After(rev.ExpiresAt) {
+				itemPolicy.AbsoluteExp = s.expiry(existing)
+			}
+		}
+
+		s.cache.Set(cache.Item{Key: key, Value: rev}, itemPolicy)
+	}
+}
+
+// expiry is when the revoked tokens are rejected by their exp anyway
+func (s *Store) expiry(rev models.Revocation) time.Time {
+	return rev.ExpiresAt.Add(s.opts.Leeway)
+}
+
+// ensureIndexes lets Mongo delete revocations once they expire
+func (s *Store) ensureIndexes(db *mongo.Database) {
+	s.indexOnce.Do(func() {
+		index := mongo.IndexModel{
+			Keys:    bson.M{"expires_at": 1},
+			Options: options.Index().SetExpireAfterSeconds(int32(s.opts.Leeway.Seconds())),
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), config.DefaultCtxTimeout)
+		defer cancel()
+
+		_, err := db.Collection(collectionName).Indexes().CreateOne(ctx, index)
+		if err != nil {
+			log.Printf("Error creating revocation index: %s\n", err)
+		}
+	})
+}
+
+func (s *Store) startRefresher() {
+	ticker := time.NewTicker(s.opts.RefreshInterval)
+
+	for {
+		if err := s.Refresh(); err != nil {
+			log.Printf("Error refreshing revocations: %s\n", err)
+		}
+
+		<-ticker.C
+	}
+}
+
+func jtiKey(iss string, jti string) string {
+	return "jti:" + iss + "|" + jti
+}
+
+func subKey(iss string, sub string) string {
+	return "sub:" + iss + "|" + sub
+}
@@ -0,0 +1,112 @@
+package revocation
+
+import (
+	"testing"
+	"time"
+
+	"dahbura.me/api/database/models"
+	"dahbura.me/api/security/jose"
+)
+
+func TestIsRevoked(t *testing.T) {
+	now := time.Now()
+	s := NewStore(StoreOpts{Leeway: time.Minute})
+	exp := now.Add(time.Hour)
+
+	revs := []models.Revocation{
+		{Jti: "revoked-jti", ExpiresAt: exp},
+		{Jti: "other-issuer-jti", Iss: "https://other/", ExpiresAt: exp},
+		{Sub: "auth0|1", IssuedBefore: now.Add(-time.Minute), ExpiresAt: exp},
+		{Sub: "auth0|1", IssuedBefore: now.Add(-time.Hour), ExpiresAt: exp},
+	}
+
+	for i := range revs {
+		if err := s.complete(&revs[i], now); err != nil {
+			t.Fatal(err)
+		}
+
+		s.remember(revs[i])
+	}
+
+	testCases := []struct {
+		name   string
+		claims jose.Claims
+		want   bool
+	}{
+		{"revoked jti", jose.Claims{Iss: "https://issuer/", Jti: "revoked-jti"}, true},
+		{"other jti", jose.Claims{Iss: "https://issuer/", Jti: "jti"}, false},
+		{"jti of other issuer", jose.Claims{Iss: "https://issuer/", Jti: "other-issuer-jti"}, false},
+		{"jti of issuer", jose.Claims{Iss: "https://other/", Jti: "other-issuer-jti"}, true},
+		{"sub issued before", jose.Claims{Sub: "auth0|1", Iat: now.Add(-time.Minute * 30).Unix()}, true},
+		{"sub issued after", jose.Claims{Sub: "auth0|1", Iat: now.Unix()}, false},
+		{"sub without iat", jose.Claims{Sub: "auth0|1"}, true},
+		{"other sub", jose.Claims{Sub: "auth0|2", Iat: now.Add(-time.Hour).Unix()}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := s.IsRevoked(&tc.claims)
+			if got != tc.want {
+				t.Fatalf(`IsRevoked() = %v, want match for %v`, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompleteRevocation(t *testing.T) {
+	now := time.Now()
+	s := NewStore(StoreOpts{Leeway: time.Minute})
+
+	rev := models.Revocation{Sub: "auth0|1", ExpiresAt: now.Add(time.Hour)}
+	err := s.complete(&rev, now)
+	if err != nil || !rev.IssuedBefore.Equal(now) || !rev.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf(`complete() = %+v, %v, want match for cutoff now, expiry in 1h`, rev, err)
+	}
+
+	err = s.complete(&models.Revocation{}, now)
+	if err == nil {
+		t.Fatalf(`complete(no jti or sub) = nil, want error`)
+	}
+
+	err = s.complete(&models.Revocation{Jti: "jti"}, now)
+	if err == nil {
+		t.Fatalf(`complete(no expiry) = nil, want error`)
+	}
+
+	err = s.complete(&models.Revocation{Jti: "jti", ExpiresAt: now.Add(-time.Second)}, now)
+	if err != nil {
+		t.Fatalf(`complete(expired within leeway) = %v, want nil`, err)
+	}
+
+	err = s.complete(&models.Revocation{Jti: "jti", ExpiresAt: now.Add(-time.Hour)}, now)
+	if err == nil {
+		t.Fatalf(`complete(expired) = nil, want error`)
+	}
+}
+
+// a token valid for longer than a day stays revoked until its exp
+func TestRevocationOutlivesDay(t *testing.T) {
+	now := time.Now()
+	s := NewStore(StoreOpts{Leeway: time.Minute})
+	exp := now.Add(time.Hour * 48)
+
+	rev := models.Revocation{Jti: "long-lived", ExpiresAt: exp}
+	if err := s.complete(&rev, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if !rev.ExpiresAt.Equal(exp) {
+		t.Fatalf(`complete() expires_at = %v, want match for %v`, rev.ExpiresAt, exp)
+	}
+
+	got := s.expiry(rev)
+	if want := exp.Add(time.Minute); !got.Equal(want) {
+		t.Fatalf(`expiry() = %v, want match for %v`, got, want)
+	}
+
+	s.remember(rev)
+
+	if !s.IsRevoked(&jose.Claims{Jti: "long-lived", Exp: exp.Unix()}) {
+		t.Fatalf(`IsRevoked() = false, want match for true`)
+	}
+}
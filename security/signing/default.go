@@ -0,0 +1,54 @@
+package signing
+
+import (
+	"os"
+	"sync"
+
+	"dahbura.me/api/config"
+	"dahbura.me/api/security/jose"
+)
+
+var (
+	keyManager     *jose.KeyManager
+	keyManagerErr  error
+	keyManagerOnce sync.Once
+)
+
+func GetKeyManager() (*jose.KeyManager, error) {
+	keyManagerOnce.Do(initKeyManager)
+
+	return keyManager, keyManagerErr
+}
+
+func initKeyManager() {
+	opts := jose.KeyManagerOpts{
+		Alg:            config.SigningAlg,
+		RotationPeriod: config.SigningKeyRotation,
+		GracePeriod:    config.SigningKeyGrace,
+		PublishAhead:   config.DefaultJwksPublishMaxAge,
+	}
+
+	keys := []*jose.SigningKey{}
+	if config.SigningKeyFile != "" {
+		pemData, err := os.ReadFile(config.SigningKeyFile)
+		if err != nil {
+			keyManager = nil
+			keyManagerErr = err
+			return
+		}
+
+		keys, err = jose.ParseSigningKeys(pemData, config.SigningAlg)
+		if err != nil {
+			keyManager = nil
+			keyManagerErr = err
+			return
+		}
+
+		// keys from the file are shared by every instance and survive
+		// restarts, generated keys would do neither so rotation is left
+		// to whoever maintains the file
+		opts.RotationPeriod = 0
+	}
+
+	keyManager, keyManagerErr = jose.NewKeyManager(opts, keys...)
+}
@@ -1,8 +1,6 @@
 package http
 
 import (
-	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -14,6 +12,13 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// Authorization header failures, the request either carries no bearer
+// credentials or they are not formatted as RFC 6750 describes
+var (
+	ErrAuthorizationMissing   = errors.New("authorization header not found")
+	ErrAuthorizationMalformed = errors.New("authorization header segment count is incorrect")
+)
+
 func DoRequest(req *http.Request) ([]byte, error) {
 	res, err := httpClient.Do(req)
 	if err != nil {
@@ -69,71 +74,42 @@ func TokenFromContext(c *gin.Context) (string, error) {
 }
 
 func TokenFromHeader(c *gin.Context) (string, error) {
-	header := c.GetHeader("Authorization")
-	if len(header) == 0 {
-		return "", errors.New("authorization header not found")
-	}
-
-	headerSegments := strings.Split(header, " ")
-	if len(headerSegments) != 2 {
-		return "", errors.New("authorization header segment count is incorrect")
-	}
-
-	schemeSegment := headerSegments[0]
-	if !strings.EqualFold(schemeSegment, "Bearer") {
-		return "", errors.New("authorization scheme not found")
-	}
-
-	tokenSegment := headerSegments[1]
-
-	return tokenSegment, nil
-}
-
-func VerifyScope(token string, scopesClaim string, scope string) error {
-	encPayload := strings.Split(token, ".")[1]
-	decPayload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	scheme, token, err := AuthorizationFromHeader(c)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	payload := map[string]interface{}{}
-	err = json.Unmarshal(decPayload, &payload)
-	if err != nil {
-		return err
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("%w: unsupported scheme %s", ErrAuthorizationMissing, scheme)
 	}
 
-	err = hasScope(payload, scopesClaim, scope)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return token, nil
 }
 
-func hasScope(payload map[string]interface{}, scopesClaim string, scope string) error {
-	scopes, exists := payload[scopesClaim]
-	if !exists {
-		return fmt.Errorf("scopes claim not found: %s", scopesClaim)
+// AuthorizationFromHeader returns the scheme, "Bearer" or "DPoP", and the
+// access token of the Authorization header
+func AuthorizationFromHeader(c *gin.Context) (string, string, error) {
+	header := c.GetHeader("Authorization")
+	if len(header) == 0 {
+		return "", "", ErrAuthorizationMissing
 	}
 
-	scopesArray, ok := scopes.([]interface{})
-	if !ok {
-		return fmt.Errorf("scopes claim not an array: %s", scopesClaim)
+	headerSegments := strings.Split(header, " ")
+	if len(headerSegments) != 2 {
+		return "", "", ErrAuthorizationMalformed
 	}
 
-	_, ok = toMap(scopesArray)[scope]
-	if !ok {
-		return fmt.Errorf("scope not found: %s", scope)
+	schemeSegment := headerSegments[0]
+	switch {
+	case strings.EqualFold(schemeSegment, "Bearer"):
+		schemeSegment = "Bearer"
+	case strings.EqualFold(schemeSegment, "DPoP"):
+		schemeSegment = "DPoP"
+	default:
+		return "", "", fmt.Errorf("%w: unsupported scheme", ErrAuthorizationMissing)
 	}
 
-	return nil
-}
-
-func toMap(values []interface{}) map[string]int {
-	m := map[string]int{}
-	for i, v := range values {
-		m[v.(string)] = i
-	}
+	tokenSegment := headerSegments[1]
 
-	return m
+	return schemeSegment, tokenSegment, nil
 }